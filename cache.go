@@ -0,0 +1,79 @@
+package structs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// structField is a struct field with its tag already parsed for a tag name.
+type structField struct {
+	reflect.StructField
+	tagName string
+	tagOpts tagOptions
+}
+
+// typeFields contains the parsed fields of a struct type for a tag name.
+type typeFields struct {
+	// all fields except the ones with the tag content of "-"
+	fields []structField
+	// the exported subset of fields
+	exported []structField
+}
+
+type cacheKey struct {
+	typ     reflect.Type
+	tagName string
+	optSep  string
+}
+
+// fieldCache caches the typeFields of every struct type and tag name pair
+// seen, so that repeated calls on the same type don't reparse the tags.
+var fieldCache sync.Map // map[cacheKey]*typeFields
+
+// cachedFields returns the parsed fields of the struct type t for the given
+// tag name. The returned value is shared and must not be modified.
+func cachedFields(t reflect.Type, tagName string) *typeFields {
+	return cachedFieldsSep(t, tagName, defaultOptionSep)
+}
+
+// cachedFieldsSep is the same as cachedFields, except that the tag options are
+// separated by optSep instead of a comma. tagName may list several tag keys
+// separated by spaces, as described in lookupTag.
+func cachedFieldsSep(t reflect.Type, tagName, optSep string) *typeFields {
+	key := cacheKey{typ: t, tagName: tagName, optSep: optSep}
+	if f, ok := fieldCache.Load(key); ok {
+		return f.(*typeFields)
+	}
+
+	f := &typeFields{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := lookupTag(field.Tag, tagName)
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := parseTagSep(tag, optSep)
+		sf := structField{
+			StructField: field,
+			tagName:     name,
+			tagOpts:     opts,
+		}
+
+		f.fields = append(f.fields, sf)
+		// we can't access the value of unexported fields
+		if field.PkgPath == "" {
+			f.exported = append(f.exported, sf)
+		}
+	}
+
+	actual, _ := fieldCache.LoadOrStore(key, f)
+	return actual.(*typeFields)
+}
+
+// typeFields returns the parsed fields of the struct type t for the TagName,
+// or TagNames, and TagOptionSep of s.
+func (s *Struct) typeFields(t reflect.Type) *typeFields {
+	return cachedFieldsSep(t, s.tagKey(), s.optionSep())
+}
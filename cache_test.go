@@ -0,0 +1,60 @@
+package structs
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCachedFields(t *testing.T) {
+	type A struct {
+		Name    string `structs:"name,omitempty" json:"-"`
+		Ignored string `structs:"-"`
+		private int
+		Count   int `json:"count"`
+	}
+
+	typ := reflect.TypeOf(A{})
+
+	f := cachedFields(typ, "structs")
+	if len(f.fields) != 3 || len(f.exported) != 2 {
+		t.Fatalf("cachedFields should return 3 fields and 2 exported, got: %d and %d", len(f.fields), len(f.exported))
+	}
+
+	if f.exported[0].tagName != "name" || !f.exported[0].tagOpts.Has("omitempty") {
+		t.Errorf("cachedFields should parse the tag of field Name, got: %q %v", f.exported[0].tagName, f.exported[0].tagOpts)
+	}
+
+	if cachedFields(typ, "structs") != f {
+		t.Error("cachedFields should return the cached fields on the second call")
+	}
+
+	j := cachedFields(typ, "json")
+	if len(j.exported) != 2 || j.exported[0].Name != "Ignored" || j.exported[1].tagName != "count" {
+		t.Errorf("cachedFields should cache fields per tag name, got: %+v", j.exported)
+	}
+}
+
+func TestCachedFields_Concurrent(t *testing.T) {
+	type A struct {
+		Name  string `structs:"name"`
+		Count int    `structs:",omitempty"`
+	}
+
+	want := map[string]interface{}{"name": "a", "Count": 1}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if m := Map(A{Name: "a", Count: 1}); !reflect.DeepEqual(m, want) {
+					t.Errorf("Map result is wrong: %v want: %v", m, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -4,19 +4,58 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	errNotExported = errors.New("field is not exported")
 	errNotSettable = errors.New("field is not settable")
+
+	// errPassedByValue is returned instead of errNotSettable if the struct
+	// was passed by value, so its fields are not addressable.
+	errPassedByValue = errors.New("field is not settable, struct was passed by value instead of by pointer")
+
+	// errReadOnly is returned by the setters of a field with the option of
+	// "readonly".
+	errReadOnly = errors.New("field is read only")
 )
 
+// settable returns an error describing why v can't be set, or nil if it can.
+func settable(v reflect.Value) error {
+	if !v.CanAddr() {
+		return errPassedByValue
+	}
+	if !v.CanSet() {
+		return errNotSettable
+	}
+	return nil
+}
+
+// multiError combines the errors of several fields into a single error.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the combined errors.
+func (m multiError) Unwrap() []error {
+	return m
+}
+
 // Field represents a single struct field that encapsulates high level
 // functions around the field.
 type Field struct {
 	value      reflect.Value
 	field      reflect.StructField
 	defaultTag string
+	owner      *Struct
 }
 
 // Tag returns the value associated with key in the tag string. If there is no
@@ -25,12 +64,71 @@ func (f *Field) Tag(key string) string {
 	return f.field.Tag.Get(key)
 }
 
+// TagLookup returns the value associated with key in the tag string, like
+// Tag. Unlike Tag it also reports whether the key is present at all, so that
+// an empty value can be told apart from a missing key.
+func (f *Field) TagLookup(key string) (string, bool) {
+	return f.field.Tag.Lookup(key)
+}
+
+// RawTag returns the whole tag string of the field.
+func (f *Field) RawTag() reflect.StructTag {
+	return f.field.Tag
+}
+
+// Tags parses the value associated with key in the tag string the same way the
+// package does. The name is the part before the first comma, and the options
+// are the comma separated values after it. The name is empty for tags such as
+// ",omitempty".
+func (f *Field) Tags(key string) (name string, options []string) {
+	return parseTag(f.field.Tag.Get(key))
+}
+
 // Value returns the underlying value of the field. It panics if the field
 // is not exported.
 func (f *Field) Value() interface{} {
 	return f.value.Interface()
 }
 
+// ValueOk is like Value, but instead of panicking it returns false if the
+// field is not exported.
+func (f *Field) ValueOk() (interface{}, bool) {
+	if !f.value.CanInterface() {
+		return nil, false
+	}
+	return f.value.Interface(), true
+}
+
+// Convert returns a copy of the field's value converted to the type t, as in
+// reflect.Value.Convert, ie: to aggregate numeric fields of different types
+// as float64. The field itself is not changed. It returns an error if the
+// field is not exported or if its value can't be converted to t.
+func (f *Field) Convert(t reflect.Type) (interface{}, error) {
+	if !f.IsExported() {
+		return nil, errNotExported
+	}
+	if t == nil || !f.value.Type().ConvertibleTo(t) {
+		return nil, fmt.Errorf("can't convert %s to %v", f.value.Type(), t)
+	}
+
+	// converting a slice to an array, or to a pointer to one, panics if the
+	// slice is too short
+	if f.value.Kind() == reflect.Slice {
+		n := -1
+		switch {
+		case t.Kind() == reflect.Array:
+			n = t.Len()
+		case t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Array:
+			n = t.Elem().Len()
+		}
+		if n > f.value.Len() {
+			return nil, fmt.Errorf("can't convert %s of length %d to %s", f.value.Type(), f.value.Len(), t)
+		}
+	}
+
+	return f.value.Convert(t).Interface(), nil
+}
+
 // IsEmbedded returns true if the given field is an anonymous field (embedded).
 func (f *Field) IsEmbedded() bool {
 	return f.field.Anonymous
@@ -41,12 +139,44 @@ func (f *Field) IsExported() bool {
 	return f.field.PkgPath == ""
 }
 
+// CanSet returns true if the field can be set with Set, that is if it's
+// exported, not read only and its struct was passed by pointer. Unlike Set it
+// doesn't change the field.
+func (f *Field) CanSet() bool {
+	return f.IsExported() && !f.readOnly() && f.value.CanSet()
+}
+
+// readOnly returns true if the field has the option of "readonly" in its tag.
+func (f *Field) readOnly() bool {
+	sep := defaultOptionSep
+	if f.owner != nil {
+		sep = f.owner.optionSep()
+	}
+
+	_, opts := parseTagSep(lookupTag(f.field.Tag, f.defaultTag), sep)
+	return opts.Has("readonly")
+}
+
 // IsZero returns true if the given field is not initialized (has a zero value).
-// It panics if the field is not exported.
+// If the field's value implements an IsZero() bool method, such as time.Time,
+// that method decides. It panics if the field is not exported.
 func (f *Field) IsZero() bool {
-	zero := reflect.Zero(f.value.Type()).Interface()
-	current := f.Value()
-	return reflect.DeepEqual(current, zero)
+	return isZeroValue(f.value)
+}
+
+// Equal returns true if f and other have the same type and deeply equal
+// values, as reported by reflect.DeepEqual. Unlike comparing the results of
+// Value it never panics: if both fields are unexported their values can't be
+// compared and they're treated as equal, if only one of them is unexported
+// they're not equal.
+func (f *Field) Equal(other *Field) bool {
+	if !f.IsExported() || !other.IsExported() {
+		return f.IsExported() == other.IsExported()
+	}
+	if f.value.Type() != other.value.Type() {
+		return false
+	}
+	return reflect.DeepEqual(f.value.Interface(), other.value.Interface())
 }
 
 // Name returns the name of the given field.
@@ -54,35 +184,497 @@ func (f *Field) Name() string {
 	return f.field.Name
 }
 
+// IndexPath returns the index sequence of the field within the struct it
+// belongs to, as in reflect.StructField.Index, for use with
+// reflect.Value.FieldByIndex. A field promoted from an embedded struct has
+// more than one index. The returned slice is a copy.
+func (f *Field) IndexPath() []int {
+	return append([]int(nil), f.field.Index...)
+}
+
 // Kind returns the fields kind, such as "string", "map", "bool", etc ..
 func (f *Field) Kind() reflect.Kind {
 	return f.value.Kind()
 }
 
+// Type returns the fields type, such as time.Time or []string. Unlike Value it
+// doesn't panic if the field is not exported.
+func (f *Field) Type() reflect.Type {
+	return f.value.Type()
+}
+
+// IsPointer returns true if the given field is a pointer.
+func (f *Field) IsPointer() bool {
+	return f.value.Kind() == reflect.Ptr
+}
+
+// Elem returns a Field wrapping the value the pointer field points to, with the
+// same name and tags as f. Setting the returned field sets the pointed value.
+// It returns false if the field is not a pointer or is a nil pointer.
+func (f *Field) Elem() (*Field, bool) {
+	if f.value.Kind() != reflect.Ptr || f.value.IsNil() {
+		return nil, false
+	}
+
+	return &Field{
+		field:      f.field,
+		value:      f.value.Elem(),
+		defaultTag: f.defaultTag,
+		owner:      f.owner,
+	}, true
+}
+
+// Addr returns a pointer to the value of the field, ie: a *int for an int
+// field, to pass it to functions which set values through pointers, such as
+// sql.Rows.Scan or flag.IntVar. It returns an error if the field is not
+// exported or not settable.
+func (f *Field) Addr() (interface{}, error) {
+	if !f.IsExported() {
+		return nil, errNotExported
+	}
+	if err := settable(f.value); err != nil {
+		return nil, err
+	}
+
+	return f.value.Addr().Interface(), nil
+}
+
+// IsSlice returns true if the given field is a slice.
+func (f *Field) IsSlice() bool {
+	return f.value.Kind() == reflect.Slice
+}
+
+// IsMap returns true if the given field is a map.
+func (f *Field) IsMap() bool {
+	return f.value.Kind() == reflect.Map
+}
+
+// IsStruct returns true if the given field is a struct or a pointer to struct.
+func (f *Field) IsStruct() bool {
+	t := f.value.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// ElemKind returns the kind of the elements of a slice, array, map or chan
+// field, or of the value a pointer field points to. It returns false for
+// fields of any other kind. It only looks at the type of the value of the
+// field, which is the pointed type for a Field returned by Elem, so it works
+// for unexported fields too.
+func (f *Field) ElemKind() (reflect.Kind, bool) {
+	switch t := f.value.Type(); t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan, reflect.Ptr:
+		return t.Elem().Kind(), true
+	}
+	return reflect.Invalid, false
+}
+
+// MapKeyKind returns the kind of the keys of a map field. It returns false for
+// fields of any other kind. Like ElemKind it works for unexported fields too.
+func (f *Field) MapKeyKind() (reflect.Kind, bool) {
+	if t := f.value.Type(); t.Kind() == reflect.Map {
+		return t.Key().Kind(), true
+	}
+	return reflect.Invalid, false
+}
+
 // Set sets the field to given value v. It returns an error if the field is not
 // settable (not addressable or not exported) or if the given value's type
-// is not assignable to the field's type.
+// is not assignable to the field's type. The fields of a struct passed to New
+// by value are never addressable, pass a pointer to the struct instead.
+// Numeric, string and boolean values are converted to the field's type if they
+// are of the same kind class, e.g. an int can be set into an int64 field,
+// unless the conversion would lose information. A nil value sets pointer,
+// interface, slice, map, channel and function fields to nil.
+//
+// A tag value with the option of "readonly" makes Set, and the other setters
+// of Field, return an error instead of changing the field. Example:
+//
+//	// Field is included by Map, but can't be set.
+//	ID int `structs:"id,readonly"`
 func (f *Field) Set(val interface{}) error {
 	// we can't set unexported fields, so be sure this field is exported
 	if !f.IsExported() {
 		return errNotExported
 	}
-	if !f.value.CanSet() {
-		return errNotSettable
+	if f.readOnly() {
+		return errReadOnly
 	}
-	value := reflect.ValueOf(val)
-	if !value.Type().AssignableTo(f.value.Type()) {
-		return fmt.Errorf("can't assign %s to %s", value.Type(), f.value.Type())
+	if err := settable(f.value); err != nil {
+		return err
+	}
+	value, err := assignValue(val, f.value.Type())
+	if err != nil {
+		return err
 	}
 	f.value.Set(value)
 	return nil
 }
 
+// SetIfZero sets the field to val with the same rules as Set, but only if the
+// field is currently a zero value, ie: to apply a default without overwriting
+// a value set before. It returns true if the field was set. It returns an
+// error if the field is not settable, even if it's not a zero value.
+func (f *Field) SetIfZero(val interface{}) (bool, error) {
+	if !f.IsExported() {
+		return false, errNotExported
+	}
+	if f.readOnly() {
+		return false, errReadOnly
+	}
+	if err := settable(f.value); err != nil {
+		return false, err
+	}
+
+	if !isZeroValue(f.value) {
+		return false, nil
+	}
+
+	if err := f.Set(val); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Append appends the given values to the slice field, converting them with
+// the same rules as Set. It returns an error if the field is not a settable
+// slice or if any value is not assignable to the slice's element type, in
+// which case the field is left unchanged.
+func (f *Field) Append(vals ...interface{}) error {
+	if !f.IsExported() {
+		return errNotExported
+	}
+	if f.readOnly() {
+		return errReadOnly
+	}
+	if err := settable(f.value); err != nil {
+		return err
+	}
+	if f.value.Kind() != reflect.Slice {
+		return fmt.Errorf("can't append to %s", f.value.Type())
+	}
+
+	values := make([]reflect.Value, len(vals))
+	for i, val := range vals {
+		value, err := assignValue(val, f.value.Type().Elem())
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+
+	f.value.Set(reflect.Append(f.value, values...))
+	return nil
+}
+
+// SetMapIndex sets the element associated with key in the map field to val,
+// converting both with the same rules as Set. The map is allocated if it's
+// nil. It returns an error if the field is not a settable map or if the key
+// or the value are not assignable to the map's key or element type.
+func (f *Field) SetMapIndex(key, val interface{}) error {
+	if !f.IsExported() {
+		return errNotExported
+	}
+	if f.readOnly() {
+		return errReadOnly
+	}
+	if err := settable(f.value); err != nil {
+		return err
+	}
+	if f.value.Kind() != reflect.Map {
+		return fmt.Errorf("can't set map index of %s", f.value.Type())
+	}
+
+	k, err := assignValue(key, f.value.Type().Key())
+	if err != nil {
+		return err
+	}
+	v, err := assignValue(val, f.value.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	if f.value.IsNil() {
+		f.value.Set(reflect.MakeMap(f.value.Type()))
+	}
+	f.value.SetMapIndex(k, v)
+	return nil
+}
+
+// assignValue returns val as a value assignable to the type t, converting it
+// if needed as described in Set. A nil val is returned as the zero value of t
+// if t is a pointer, interface, slice, map, channel or function type.
+func assignValue(val interface{}, t reflect.Type) (reflect.Value, error) {
+	if val == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("can't assign nil to %s", t)
+	}
+
+	value := reflect.ValueOf(val)
+	if value.Type().AssignableTo(t) {
+		return value, nil
+	}
+
+	converted, ok := convert(value, t)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("can't assign %s to %s", value.Type(), t)
+	}
+	return converted, nil
+}
+
+// SetFromString parses str into the field's type and sets the field to the
+// result. Booleans and numbers are parsed with strconv, time.Duration with
+// time.ParseDuration, and strings are set as they are. A slice is set from the
+// comma separated elements of str, as described in SetSlice. It returns an
+// error if the field is not settable, if str can't be parsed, or if the field
+// is of any other kind, such as a struct or map.
+func (f *Field) SetFromString(str string) error {
+	if f.value.Kind() == reflect.Slice {
+		return f.SetSlice(str, ",")
+	}
+
+	if !f.IsExported() {
+		return errNotExported
+	}
+	if f.readOnly() {
+		return errReadOnly
+	}
+	if err := settable(f.value); err != nil {
+		return err
+	}
+
+	return setFromString(f.value, str)
+}
+
+// SetSlice splits str on sep and sets the slice field to the elements, each
+// one parsed into the element type as described in SetFromString. An empty
+// str sets the field to an empty, non nil slice. Quoting or escaping sep is
+// not supported. It returns an error if the field is not settable, if it's
+// not a slice, or if an element can't be parsed.
+func (f *Field) SetSlice(str, sep string) error {
+	if !f.IsExported() {
+		return errNotExported
+	}
+	if f.readOnly() {
+		return errReadOnly
+	}
+	if err := settable(f.value); err != nil {
+		return err
+	}
+
+	typ := f.value.Type()
+	if typ.Kind() != reflect.Slice {
+		return fmt.Errorf("can't set %s from a separated string", typ)
+	}
+
+	var parts []string
+	if str != "" {
+		parts = strings.Split(str, sep)
+	}
+
+	slice := reflect.MakeSlice(typ, len(parts), len(parts))
+	for i, part := range parts {
+		if err := setFromString(slice.Index(i), part); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+
+	f.value.Set(slice)
+	return nil
+}
+
+// setFromString parses str into the type of v and sets v to the result, for
+// SetFromString.
+func setFromString(v reflect.Value, str string) error {
+	typ := v.Type()
+	if typ == durationType {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(str, 10, typ.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(str, 10, typ.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		fl, err := strconv.ParseFloat(str, typ.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(fl)
+	default:
+		return fmt.Errorf("can't set %s from a string", typ)
+	}
+
+	return nil
+}
+
+// convert converts v to the type t if both are numeric, both are strings or
+// both are booleans, and the conversion doesn't lose information.
+func convert(v reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	vc, tc := kindClass(v.Kind()), kindClass(t.Kind())
+	if vc == 0 || vc != tc || !v.Type().ConvertibleTo(t) {
+		return reflect.Value{}, false
+	}
+
+	converted := v.Convert(t)
+	if vc == numericClass {
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			// rounding to the nearest float is fine, overflowing is not
+			if v.Kind() == reflect.Float64 && converted.OverflowFloat(v.Float()) {
+				return reflect.Value{}, false
+			}
+		default:
+			// negative integers must not wrap around into unsigned ones
+			if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr &&
+				v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64 && v.Int() < 0 {
+				return reflect.Value{}, false
+			}
+
+			// large unsigned integers must not wrap around into negative
+			// ones, which converting back wouldn't notice
+			if t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64 &&
+				v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uintptr && converted.Int() < 0 {
+				return reflect.Value{}, false
+			}
+
+			// converting back must yield the original value, otherwise it
+			// was truncated or overflowed
+			if converted.Convert(v.Type()).Interface() != v.Interface() {
+				return reflect.Value{}, false
+			}
+		}
+	}
+
+	return converted, true
+}
+
+const (
+	numericClass = iota + 1
+	stringClass
+	boolClass
+)
+
+// kindClass returns the class of the kind k used for conversions in Set, or 0
+// if values of k are never converted.
+func kindClass(k reflect.Kind) int {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return numericClass
+	case reflect.String:
+		return stringClass
+	case reflect.Bool:
+		return boolClass
+	}
+	return 0
+}
+
 // Zero sets the field to its zero value. It returns an error if the field is not
 // settable (not addressable or not exported).
 func (f *Field) Zero() error {
-	zero := reflect.Zero(f.value.Type()).Interface()
-	return f.Set(zero)
+	if !f.IsExported() {
+		return errNotExported
+	}
+	if f.readOnly() {
+		return errReadOnly
+	}
+	if err := settable(f.value); err != nil {
+		return err
+	}
+	// set the zero value directly, since the zero value of an interface
+	// field is a nil interface{} which Set can't handle
+	f.value.Set(reflect.Zero(f.value.Type()))
+	return nil
+}
+
+// SetNil sets the field, which must be a pointer, slice, map, interface,
+// channel or function, to nil. Unlike Zero it returns an error for any other
+// kind of field, ie: to tell clearing a reference from zeroing a value. It
+// returns the same errors as Zero if the field can't be set.
+func (f *Field) SetNil() error {
+	switch f.value.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface,
+		reflect.Chan, reflect.Func:
+	default:
+		return fmt.Errorf("can't set field of kind %s to nil", f.value.Kind())
+	}
+	return f.Zero()
+}
+
+// ZeroNested is like Zero, but if the field is a struct or a non-nil pointer
+// to struct it doesn't replace the whole value. Instead it descends into it
+// and sets each exported leaf field to its zero value, leaving unexported
+// fields intact. Structs without exported fields, such as time.Time, are
+// zeroed as a whole. A struct tag with the content of "-" ignores that
+// particular field. A field with the option of "readonly" is not descended
+// into, its fields are read only too. It returns an error listing every leaf
+// field that couldn't be set.
+func (f *Field) ZeroNested() error {
+	// the fields of a read only struct are read only too
+	if f.readOnly() {
+		return errReadOnly
+	}
+
+	v := f.value
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return f.Zero()
+	}
+
+	var exported []*Field
+	for _, field := range getFields(f.owner.structFor(v)) {
+		if field.IsExported() {
+			exported = append(exported, field)
+		}
+	}
+
+	// structs without exported fields, ie: time.Time, are leaves themselves
+	if len(exported) == 0 {
+		return f.Zero()
+	}
+
+	var errs multiError
+	for _, field := range exported {
+		if err := field.ZeroNested(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field.Name(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 // Fields returns a slice of Fields. This is particular handy to get the fields
@@ -92,13 +684,35 @@ func (f *Field) Zero() error {
 //	// Field is ignored by this package.
 //	Field *http.Request `structs:"-"`
 //
+// If FollowInterfaces is set, the fields of the struct an interface field
+// holds are returned, or nil if it doesn't hold one.
+//
 // It panics if field is not exported or if field's kind is not struct.
 func (f *Field) Fields() []*Field {
-	return getFields(f.value, f.defaultTag)
+	v := f.value
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Interface && f.owner.FollowInterfaces {
+		// skip nil interfaces and the ones holding anything but a struct
+		if v = f.owner.indirect(v); v.Kind() != reflect.Struct {
+			return nil
+		}
+	}
+	return getFields(f.owner.structFor(v))
 }
 
-// Field returns the field from a nested struct or nil if not found.
+// Field returns the field from a nested struct or nil if not found. If
+// FollowInterfaces is set, the struct an interface field holds is used.
 func (f *Field) Field(name string) *Field {
+	if f.value.Kind() == reflect.Interface && f.owner.FollowInterfaces {
+		v := f.owner.indirect(f.value)
+		if v.Kind() != reflect.Struct {
+			return nil
+		}
+		return f.owner.structFor(v).Field(name)
+	}
+
 	value := &f.value
 	// value must be settable so we need to make sure it holds the address of the
 	// variable and not a copy, so we can pass the pointer to structVal instead of a
@@ -116,7 +730,34 @@ func (f *Field) Field(name string) *Field {
 	}
 
 	return &Field{
-		field: field,
-		value: v.FieldByName(name),
+		field:      field,
+		value:      v.FieldByName(name),
+		defaultTag: f.defaultTag,
+		owner:      f.owner.structFor(v),
+	}
+}
+
+// StructValue returns a *Struct with the nested struct the field holds, or
+// points to, with the same options as the Struct of the field. If the field's
+// struct was passed by pointer the returned Struct can set the nested fields.
+// If FollowInterfaces is set, the struct an interface field holds is used. It
+// returns false if the field is not exported, is a nil pointer or doesn't hold
+// a struct.
+func (f *Field) StructValue() (*Struct, bool) {
+	if !f.IsExported() {
+		return nil, false
+	}
+
+	v := f.owner.indirect(f.value)
+	if v.Kind() != reflect.Struct {
+		return nil, false
 	}
+	return f.owner.structFor(v), true
+}
+
+// Owner returns the Struct the field belongs to. For fields of nested structs,
+// such as the ones returned by Field.Field, Field.Fields or
+// Struct.FieldByPath, it is the nested struct.
+func (f *Field) Owner() *Struct {
+	return f.owner
 }
@@ -1,8 +1,12 @@
 package structs
 
 import (
+	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // A test struct that defines all cases
@@ -132,6 +136,285 @@ func TestField_Set(t *testing.T) {
 	}
 }
 
+func TestField_SetConvert(t *testing.T) {
+	type Level string
+
+	type A struct {
+		I64   int64
+		I32   int32
+		I8    int8
+		U     uint
+		F32   float32
+		Level Level
+		Any   interface{}
+		Str   fmt.Stringer
+		Baz   Baz
+	}
+
+	a := &A{}
+	s := New(a)
+
+	for _, tt := range []struct {
+		name string
+		val  interface{}
+		want interface{}
+	}{
+		{"I64", 42, int64(42)},
+		{"I8", int64(-128), int8(-128)},
+		{"I32", uint32(5), int32(5)},
+		{"I8", 3.0, int8(3)},
+		{"U", 7, uint(7)},
+		{"F32", 1.5, float32(1.5)},
+		{"F32", 2, float32(2)},
+		{"Level", "debug", Level("debug")},
+		{"Any", &Baz{A: "baz"}, &Baz{A: "baz"}},
+		{"Str", &Person{Name: "fatih"}, &Person{Name: "fatih"}},
+	} {
+		f := s.Field(tt.name)
+		if err := f.Set(tt.val); err != nil {
+			t.Errorf("Setting %v (%T) into field %s should not fail: %s", tt.val, tt.val, tt.name, err)
+			continue
+		}
+		if got := f.Value(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Setted value of field %s is wrong: %v (%T) want: %v (%T)", tt.name, got, got, tt.want, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		val  interface{}
+	}{
+		{"I8", 300},
+		{"I8", 1.5},
+		{"U", -1},
+		{"I8", uint8(200)},
+		{"I32", uint32(3e9)},
+		{"I64", uint64(math.MaxUint64)},
+		{"F32", 1e300},
+		{"I64", "42"},
+		{"Level", 42},
+		{"Level", true},
+		{"Str", Baz{}},
+		{"Baz", "baz"},
+	} {
+		if err := s.Field(tt.name).Set(tt.val); err == nil {
+			t.Errorf("Setting %v (%T) into field %s should fail", tt.val, tt.val, tt.name)
+		}
+	}
+}
+
+func TestField_SetFromString(t *testing.T) {
+	type Level string
+
+	type A struct {
+		Name    string
+		Level   Level
+		Debug   bool
+		Count   int8
+		Size    uint64
+		Ratio   float32
+		Timeout time.Duration
+		Tags    []string
+		Baz     Baz
+		private int
+	}
+
+	a := &A{}
+	s := New(a)
+
+	for _, tt := range []struct {
+		name, str string
+	}{
+		{"Name", "gopher"},
+		{"Level", "debug"},
+		{"Debug", "true"},
+		{"Count", "-12"},
+		{"Size", "1024"},
+		{"Ratio", "0.5"},
+		{"Timeout", "1m30s"},
+	} {
+		if err := s.Field(tt.name).SetFromString(tt.str); err != nil {
+			t.Errorf("SetFromString of field %s from %q should not fail: %s", tt.name, tt.str, err)
+		}
+	}
+
+	want := &A{
+		Name:    "gopher",
+		Level:   "debug",
+		Debug:   true,
+		Count:   -12,
+		Size:    1024,
+		Ratio:   0.5,
+		Timeout: 90 * time.Second,
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("SetFromString result is wrong: %+v want: %+v", a, want)
+	}
+
+	for _, tt := range []struct {
+		name, str string
+	}{
+		{"Debug", "maybe"},
+		{"Count", "300"},
+		{"Size", "-1"},
+		{"Ratio", "half"},
+		{"Timeout", "10"},
+		{"Baz", "baz"},
+		{"private", "1"},
+	} {
+		if err := s.Field(tt.name).SetFromString(tt.str); err == nil {
+			t.Errorf("SetFromString of field %s from %q should fail", tt.name, tt.str)
+		}
+	}
+
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Failed SetFromString calls should not change the struct: %+v want: %+v", a, want)
+	}
+}
+
+func TestField_SetSlice(t *testing.T) {
+	type A struct {
+		Hosts    []string
+		Ports    []int
+		Weights  []float64
+		Timeouts []time.Duration
+		Matrix   [][]int
+		Name     string
+	}
+
+	a := &A{}
+	s := New(a)
+
+	if err := s.Field("Hosts").SetFromString("a,b,c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Field("Ports").SetSlice("80 443", " "); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Field("Weights").SetSlice("0.5;1.5", ";"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Field("Timeouts").SetFromString("1s,2m"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &A{
+		Hosts:    []string{"a", "b", "c"},
+		Ports:    []int{80, 443},
+		Weights:  []float64{0.5, 1.5},
+		Timeouts: []time.Duration{time.Second, 2 * time.Minute},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("SetSlice result is wrong: %+v want: %+v", a, want)
+	}
+
+	if err := s.Field("Hosts").SetFromString(""); err != nil {
+		t.Fatal(err)
+	}
+	if a.Hosts == nil || len(a.Hosts) != 0 {
+		t.Errorf("SetSlice from an empty string: got %#v, want an empty non nil slice", a.Hosts)
+	}
+
+	for _, tt := range []struct {
+		name, str string
+	}{
+		{"Ports", "80,http"},
+		{"Matrix", "1,2"},
+		{"Name", "a,b"},
+	} {
+		if err := s.Field(tt.name).SetSlice(tt.str, ","); err == nil {
+			t.Errorf("SetSlice of field %s from %q should fail", tt.name, tt.str)
+		}
+	}
+
+	if err := New(A{}).Field("Hosts").SetSlice("a", ","); err == nil {
+		t.Error("SetSlice on a struct passed by value should fail")
+	}
+}
+
+func TestField_Append(t *testing.T) {
+	type A struct {
+		Tags  []string
+		IDs   []int64
+		Items []*Baz
+		Name  string
+		tags  []string
+	}
+
+	a := &A{Tags: []string{"a"}}
+	s := New(a)
+
+	if err := s.Field("Tags").Append("b", "c"); err != nil {
+		t.Error(err)
+	}
+	if err := s.Field("IDs").Append(1, int8(2)); err != nil {
+		t.Error(err)
+	}
+	if err := s.Field("Items").Append(&Baz{A: "baz"}, nil); err != nil {
+		t.Error(err)
+	}
+
+	want := &A{
+		Tags:  []string{"a", "b", "c"},
+		IDs:   []int64{1, 2},
+		Items: []*Baz{{A: "baz"}, nil},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Append result is wrong: %+v want: %+v", a, want)
+	}
+
+	if err := s.Field("Tags").Append("d", 1); err == nil {
+		t.Error("Append of a value with a different type should return an error")
+	}
+	if len(a.Tags) != 3 {
+		t.Errorf("Failed Append should not change the slice, got: %v", a.Tags)
+	}
+
+	if err := s.Field("Name").Append("a"); err == nil {
+		t.Error("Append to a non-slice field should return an error")
+	}
+
+	if err := s.Field("tags").Append("a"); err != errNotExported {
+		t.Errorf("Append to an unexported field should error with %q, got: %v", errNotExported, err)
+	}
+}
+
+func TestField_SetMapIndex(t *testing.T) {
+	type A struct {
+		Labels map[string]string
+		Counts map[int64]float64
+		Name   string
+	}
+
+	a := &A{}
+	s := New(a)
+
+	if err := s.Field("Labels").SetMapIndex("env", "prod"); err != nil {
+		t.Error(err)
+	}
+	if err := s.Field("Counts").SetMapIndex(1, 2); err != nil {
+		t.Error(err)
+	}
+
+	want := &A{
+		Labels: map[string]string{"env": "prod"},
+		Counts: map[int64]float64{1: 2},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("SetMapIndex result is wrong: %+v want: %+v", a, want)
+	}
+
+	if err := s.Field("Labels").SetMapIndex(1, "a"); err == nil {
+		t.Error("SetMapIndex with a key of a different type should return an error")
+	}
+	if err := s.Field("Labels").SetMapIndex("a", 1); err == nil {
+		t.Error("SetMapIndex with a value of a different type should return an error")
+	}
+	if err := s.Field("Name").SetMapIndex("a", "b"); err == nil {
+		t.Error("SetMapIndex of a non-map field should return an error")
+	}
+}
+
 func TestField_NotSettable(t *testing.T) {
 	a := map[int]Baz{
 		4: {
@@ -141,8 +424,279 @@ func TestField_NotSettable(t *testing.T) {
 
 	s := New(a[4])
 
-	if err := s.Field("A").Set("newValue"); err != errNotSettable {
-		t.Errorf("Trying to set non-settable field should error with %q. Got %q instead.", errNotSettable, err)
+	if err := s.Field("A").Set("newValue"); err != errPassedByValue {
+		t.Errorf("Trying to set non-settable field should error with %q. Got %q instead.", errPassedByValue, err)
+	}
+
+	type inner struct {
+		Name string
+	}
+	type outer struct {
+		in inner
+	}
+
+	f, _ := New(&outer{}).FieldByPath("in.Name")
+	if err := f.Set("newValue"); err != errNotSettable {
+		t.Errorf("Trying to set field of unexported struct should error with %q. Got %q instead.", errNotSettable, err)
+	}
+}
+
+func TestField_FieldByIndex(t *testing.T) {
+	s := newStruct()
+
+	sf, _ := reflect.TypeOf(Foo{}).FieldByName("F")
+
+	f, ok := s.FieldByIndex(sf.Index)
+	if !ok {
+		t.Fatalf("The promoted field F at %v should exist.", sf.Index)
+	}
+	if f.Name() != "F" || f.Value().(int) != 2 {
+		t.Errorf("The promoted field F should have the value 2, got: %s %v", f.Name(), f.Value())
+	}
+
+	if err := f.Set(3); err != nil {
+		t.Error(err)
+	}
+	if val := s.Field("Bar").Field("F").Value().(int); val != 3 {
+		t.Errorf("The value of field 'Bar.F' should be 3, got: %d", val)
+	}
+
+	for _, index := range [][]int{nil, {-1}, {100}, {0, 0}, {4, 0}} {
+		if f, ok := s.FieldByIndex(index); ok || f != nil {
+			t.Errorf("The index %v should not be found", index)
+		}
+	}
+}
+
+func TestField_FieldValue(t *testing.T) {
+	s := newStruct()
+
+	if v, ok := s.FieldValue("A"); !ok || v != "gopher" {
+		t.Errorf("FieldValue of 'A': got %v, %t", v, ok)
+	}
+	if v, ok := s.FieldValue("F"); !ok || v != 2 {
+		t.Errorf("FieldValue of the promoted 'F': got %v, %t", v, ok)
+	}
+
+	for _, name := range []string{"d", "g", "Missing"} {
+		if v, ok := s.FieldValue(name); ok || v != nil {
+			t.Errorf("FieldValue of %q should fail, got %v, %t", name, v, ok)
+		}
+	}
+
+	// F is promoted through a nil *Bar
+	if v, ok := New(&Foo{}).FieldValue("F"); ok || v != nil {
+		t.Errorf("FieldValue through a nil embedded pointer should fail, got %v, %t", v, ok)
+	}
+}
+
+func TestHasFieldHasTag(t *testing.T) {
+	s := newStruct()
+
+	for _, name := range []string{"A", "d", "Bar", "F"} {
+		if !s.HasField(name) {
+			t.Errorf("HasField of %q should be true", name)
+		}
+	}
+
+	if s.HasField("no-field") {
+		t.Error("HasField of a non-existing field should be false")
+	}
+
+	if !s.HasTag("structs", "y") || !s.HasTag("json", "c") {
+		t.Error("HasTag of existing tag names should be true")
+	}
+
+	if s.HasTag("structs", "B") || s.HasTag("json", "y") || s.HasTag("db", "") {
+		t.Error("HasTag of non-existing tag names should be false")
+	}
+}
+
+func TestSetByTag(t *testing.T) {
+	type A struct {
+		UserID  int    `db:"user_id"`
+		Name    string `db:"name,omitempty"`
+		Ignored string `db:"-"`
+		private string `db:"private"`
+	}
+
+	a := &A{}
+	s := New(a)
+
+	if err := s.SetByTag("db", "user_id", 42); err != nil {
+		t.Error(err)
+	}
+
+	if err := s.SetByTag("db", "name", "gopher"); err != nil {
+		t.Error(err)
+	}
+
+	if a.UserID != 42 || a.Name != "gopher" {
+		t.Errorf("SetByTag should set the fields, got: %+v", a)
+	}
+
+	for _, name := range []string{"-", "Ignored", "private", "unknown"} {
+		err := s.SetByTag("db", name, "value")
+		if err == nil || !strings.Contains(err.Error(), "no field with tag") {
+			t.Errorf("SetByTag of tag name %q should fail with no field error, got: %v", name, err)
+		}
+	}
+
+	if err := s.SetByTag("db", "name", 1); err == nil || strings.Contains(err.Error(), "no field with tag") {
+		t.Errorf("SetByTag with a mismatched type should fail with the Set error, got: %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	type Config struct {
+		Name    string `structs:"name"`
+		Port    int    `structs:"port"`
+		Debug   bool
+		Secret  string `structs:"-"`
+		private int
+	}
+
+	c := &Config{Name: "old", Port: 80}
+	s := New(c)
+
+	if err := s.Apply(map[string]interface{}{"name": "new", "Port": 8080, "Debug": true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Config{Name: "new", Port: 8080, Debug: true}); *c != want {
+		t.Errorf("Apply: got %+v, want %+v", *c, want)
+	}
+
+	for _, updates := range []map[string]interface{}{
+		{"name": "other", "port": "not a number"},
+		{"Debug": false, "missing": 1},
+		{"Name": "other", "private": 1},
+		{"Name": "other", "Secret": "s"},
+	} {
+		before := *c
+		err := s.Apply(updates)
+		if err == nil {
+			t.Errorf("Apply(%v) should fail", updates)
+			continue
+		}
+		if *c != before {
+			t.Errorf("Apply(%v) should roll back, got %+v, want %+v", updates, *c, before)
+		}
+	}
+
+	err := s.Apply(map[string]interface{}{"port": "x"})
+	if err == nil || !strings.HasPrefix(err.Error(), "port: ") {
+		t.Errorf("Apply should report the failed key, got: %v", err)
+	}
+
+	if err := New(Config{}).Apply(map[string]interface{}{"Name": "x"}); err == nil {
+		t.Error("Apply on a struct passed by value should fail")
+	}
+
+	// F is promoted through a nil *Bar
+	err = New(&Foo{}).Apply(map[string]interface{}{"F": 1})
+	if err == nil || !strings.HasPrefix(err.Error(), "F: ") {
+		t.Errorf("Apply through a nil embedded pointer should report the key, got: %v", err)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	type User struct {
+		Name    string
+		Age     int
+		Admin   bool
+		Email   *string
+		private string
+	}
+	type UserPatch struct {
+		Name    *string
+		Age     *int
+		Admin   *bool
+		Email   *string
+		Ignored string
+	}
+
+	name, admin, email := "jane", false, "jane@example.com"
+	u := &User{Name: "john", Age: 30, Admin: true}
+
+	patch := UserPatch{Name: &name, Admin: &admin, Email: &email, Ignored: "x"}
+	if err := New(u).Patch(&patch); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+
+	if u.Name != "jane" || u.Age != 30 || u.Admin {
+		t.Errorf("Patch should set the non-nil fields only: got %+v", u)
+	}
+	if u.Email == nil || *u.Email != email || u.Email == &email {
+		t.Errorf("Patch should set pointer fields to a copy of the value: got %v", u.Email)
+	}
+
+	type Bad struct {
+		Name    *string
+		Missing *int
+	}
+	missing := 1
+	if err := New(u).Patch(Bad{Name: &email, Missing: &missing}); err == nil {
+		t.Error("Patch with an unknown field should fail")
+	}
+	if u.Name != "jane" {
+		t.Errorf("a failed Patch should not change any field: got Name %q", u.Name)
+	}
+
+	if err := New(u).Patch(42); err == nil {
+		t.Error("Patch with a non struct source should fail")
+	}
+	if err := New(*u).Patch(patch); err == nil {
+		t.Error("Patch of a non settable struct should fail")
+	}
+}
+
+func TestField_SetNil(t *testing.T) {
+	type A struct {
+		Ptr   *int
+		Slice []string
+		Map   map[string]int
+		Iface interface{}
+		Chan  chan int
+		Func  func()
+		Int   int
+		Str   string
+		ID    *int `structs:",readonly"`
+	}
+
+	n := 1
+	a := &A{
+		Ptr:   &n,
+		Slice: []string{"a"},
+		Map:   map[string]int{"a": 1},
+		Iface: 1,
+		Chan:  make(chan int),
+		Func:  func() {},
+		Int:   1,
+		Str:   "a",
+		ID:    &n,
+	}
+	s := New(a)
+
+	for _, name := range []string{"Ptr", "Slice", "Map", "Iface", "Chan", "Func"} {
+		if err := s.Field(name).SetNil(); err != nil {
+			t.Errorf("SetNil of %q: %v", name, err)
+		}
+		if !s.Field(name).IsZero() {
+			t.Errorf("SetNil of %q should set it to nil, got: %v", name, s.Field(name).Value())
+		}
+	}
+
+	for _, name := range []string{"Int", "Str"} {
+		if err := s.Field(name).SetNil(); err == nil {
+			t.Errorf("SetNil of %q should fail", name)
+		}
+	}
+	if a.Int != 1 || a.Str != "a" {
+		t.Errorf("a failed SetNil should not change the field: got %+v", a)
+	}
+
+	if err := s.Field("ID").SetNil(); err != errReadOnly {
+		t.Errorf("SetNil of a readonly field: got %v, want %v", err, errReadOnly)
 	}
 }
 
@@ -205,6 +759,54 @@ func TestField_Zero(t *testing.T) {
 	}
 }
 
+func TestField_ZeroNested(t *testing.T) {
+	type Inner struct {
+		Name    string
+		Count   int
+		private string
+		Ignored string `structs:"-"`
+		Created time.Time
+	}
+
+	type Outer struct {
+		Inner  Inner
+		Ptr    *Inner
+		Nil    *Inner
+		Single string
+	}
+
+	o := &Outer{
+		Inner:  Inner{Name: "a", Count: 1, private: "keep", Ignored: "keep", Created: time.Now()},
+		Ptr:    &Inner{Name: "b", Count: 2, private: "keep"},
+		Single: "single",
+	}
+	s := New(o)
+
+	for _, name := range []string{"Inner", "Ptr", "Nil", "Single"} {
+		if err := s.Field(name).ZeroNested(); err != nil {
+			t.Errorf("ZeroNested of field %s should not fail: %s", name, err)
+		}
+	}
+
+	want := &Outer{
+		Inner: Inner{private: "keep", Ignored: "keep"},
+		Ptr:   &Inner{private: "keep"},
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("ZeroNested result is wrong: %+v want: %+v", o, want)
+	}
+
+	a := map[int]Outer{4: {Inner: Inner{Name: "a"}}}
+	err := New(a[4]).Field("Inner").ZeroNested()
+	if err == nil {
+		t.Fatal("ZeroNested of a non-settable struct should fail")
+	}
+	if !strings.Contains(err.Error(), "Name: "+errPassedByValue.Error()) ||
+		!strings.Contains(err.Error(), "Count: "+errPassedByValue.Error()) {
+		t.Errorf("ZeroNested error should list every failed leaf field, got: %s", err)
+	}
+}
+
 func TestField(t *testing.T) {
 	s := newStruct()
 
@@ -234,6 +836,109 @@ func TestField_Kind(t *testing.T) {
 	}
 }
 
+func TestField_Type(t *testing.T) {
+	s := newStruct()
+
+	if typ := s.Field("A").Type(); typ != reflect.TypeOf("") {
+		t.Errorf("Field A has wrong type: %s want: %s", typ, reflect.TypeOf(""))
+	}
+
+	if typ := s.Field("E").Type(); typ != reflect.TypeOf(&Baz{}) {
+		t.Errorf("Field E has wrong type: %s want: %s", typ, reflect.TypeOf(&Baz{}))
+	}
+
+	if typ := s.Field("Y").Type().Elem(); typ.Kind() != reflect.String {
+		t.Errorf("Field Y has wrong element type: %s want: %s", typ, reflect.String)
+	}
+
+	// unexported
+	if typ := s.Field("d").Type(); typ != reflect.TypeOf("") {
+		t.Errorf("Field d has wrong type: %s want: %s", typ, reflect.TypeOf(""))
+	}
+}
+
+func TestField_Elem(t *testing.T) {
+	type A struct {
+		Count *int
+		Name  string
+		Nil   *string
+	}
+
+	count := 1
+	s := New(&A{Count: &count})
+
+	if !s.Field("Count").IsPointer() {
+		t.Error("Field Count is a pointer field")
+	}
+
+	if s.Field("Name").IsPointer() {
+		t.Error("Field Name is not a pointer field")
+	}
+
+	f, ok := s.Field("Count").Elem()
+	if !ok {
+		t.Fatal("Elem of non-nil pointer field Count should succeed")
+	}
+
+	if f.Kind() != reflect.Int {
+		t.Errorf("Elem of field Count has wrong kind: %s want: %s", f.Kind(), reflect.Int)
+	}
+
+	if err := f.Set(2); err != nil {
+		t.Error(err)
+	}
+
+	if count != 2 {
+		t.Errorf("Setting Elem of field Count should set the pointed value, got: %d", count)
+	}
+
+	if f, ok := s.Field("Nil").Elem(); ok || f != nil {
+		t.Error("Elem of a nil pointer field should return false")
+	}
+
+	if f, ok := s.Field("Name").Elem(); ok || f != nil {
+		t.Error("Elem of a non-pointer field should return false")
+	}
+}
+
+func TestField_IsSliceMapStruct(t *testing.T) {
+	type A struct {
+		Slice []string
+		Map   map[string]int
+		Baz   Baz
+		Ptr   *Baz
+		Name  string
+		slice []int
+		baz   *Baz
+	}
+
+	s := New(&A{})
+
+	for _, tt := range []struct {
+		name                  string
+		isSlice, isMap, isStr bool
+	}{
+		{"Slice", true, false, false},
+		{"Map", false, true, false},
+		{"Baz", false, false, true},
+		{"Ptr", false, false, true},
+		{"Name", false, false, false},
+		{"slice", true, false, false},
+		{"baz", false, false, true},
+	} {
+		f := s.Field(tt.name)
+		if f.IsSlice() != tt.isSlice {
+			t.Errorf("Field %s IsSlice should be %t", tt.name, tt.isSlice)
+		}
+		if f.IsMap() != tt.isMap {
+			t.Errorf("Field %s IsMap should be %t", tt.name, tt.isMap)
+		}
+		if f.IsStruct() != tt.isStr {
+			t.Errorf("Field %s IsStruct should be %t", tt.name, tt.isStr)
+		}
+	}
+}
+
 func TestField_Tag(t *testing.T) {
 	s := newStruct()
 
@@ -258,6 +963,61 @@ func TestField_Tag(t *testing.T) {
 	}
 }
 
+func TestField_TagLookup(t *testing.T) {
+	type T struct {
+		Name string `structs:"name" validate:"" db:"user_name"`
+		Bare string
+	}
+
+	s := New(&T{})
+	f := s.Field("Name")
+
+	if v, ok := f.TagLookup("db"); !ok || v != "user_name" {
+		t.Errorf("TagLookup(db): got %q, %v, want %q, true", v, ok, "user_name")
+	}
+	if v, ok := f.TagLookup("validate"); !ok || v != "" {
+		t.Errorf("TagLookup(validate): got %q, %v, want an empty value and true", v, ok)
+	}
+	if _, ok := f.TagLookup("json"); ok {
+		t.Error("TagLookup of a missing key should return false")
+	}
+
+	if raw, want := f.RawTag(), reflect.StructTag(`structs:"name" validate:"" db:"user_name"`); raw != want {
+		t.Errorf("RawTag: got %q, want %q", raw, want)
+	}
+	if raw := s.Field("Bare").RawTag(); raw != "" {
+		t.Errorf("RawTag of an untagged field: got %q, want an empty tag", raw)
+	}
+}
+
+func TestField_Tags(t *testing.T) {
+	type A struct {
+		Name  string `json:"name,omitempty,string"`
+		Value int    `json:",omitempty"`
+		Empty bool
+	}
+
+	s := New(&A{})
+
+	for _, tt := range []struct {
+		field   string
+		name    string
+		options []string
+	}{
+		{"Name", "name", []string{"omitempty", "string"}},
+		{"Value", "", []string{"omitempty"}},
+		{"Empty", "", []string{}},
+	} {
+		name, options := s.Field(tt.field).Tags("json")
+		if name != tt.name {
+			t.Errorf("Field %s should have the tag name %q, got: %q", tt.field, tt.name, name)
+		}
+		if !reflect.DeepEqual(options, tt.options) {
+			t.Errorf("Field %s should have the tag options %v, got: %v", tt.field, tt.options, options)
+		}
+	}
+}
+
 func TestField_Value(t *testing.T) {
 	s := newStruct()
 
@@ -282,6 +1042,24 @@ func TestField_Value(t *testing.T) {
 	_ = s.Field("d").Value()
 }
 
+func TestField_ValueOk(t *testing.T) {
+	s := newStruct()
+
+	v, ok := s.Field("A").ValueOk()
+	if !ok {
+		t.Error("ValueOk of an exported field should succeed")
+	}
+
+	if val, _ := v.(string); val != "gopher" {
+		t.Errorf("Field's value of a existing tag should return 'gopher', got: %v", v)
+	}
+
+	v, ok = s.Field("d").ValueOk()
+	if ok || v != nil {
+		t.Errorf("ValueOk of a non exported field should return false, got: %v", v)
+	}
+}
+
 func TestField_IsEmbedded(t *testing.T) {
 	s := newStruct()
 
@@ -322,6 +1100,63 @@ func TestField_IsZero(t *testing.T) {
 	}
 }
 
+func TestField_IsZero_Zeroer(t *testing.T) {
+	type A struct {
+		Time time.Time
+	}
+
+	a := A{Time: time.Time{}.In(time.FixedZone("UTC+1", 60*60))}
+	if !New(a).Field("Time").IsZero() {
+		t.Error("Field 'Time' should be zero as reported by time.Time.IsZero")
+	}
+
+	a.Time = time.Now()
+	if New(a).Field("Time").IsZero() {
+		t.Error("Field 'Time' should not be zero")
+	}
+}
+
+func TestField_Convert(t *testing.T) {
+	type Celsius float32
+	type A struct {
+		Count   int
+		Temp    Celsius
+		Name    string
+		Bytes   []byte
+		private int
+	}
+
+	a := A{Count: 3, Temp: 21.5, Name: "abc", Bytes: []byte{1, 2}}
+	s := New(&a)
+
+	float64Type := reflect.TypeOf(float64(0))
+	if v, err := s.Field("Count").Convert(float64Type); err != nil || v != float64(3) {
+		t.Errorf("Convert of 'Count' to float64: got %v, %v", v, err)
+	}
+	if v, err := s.Field("Temp").Convert(float64Type); err != nil || v != float64(21.5) {
+		t.Errorf("Convert of 'Temp' to float64: got %v, %v", v, err)
+	}
+	if v, err := s.Field("Name").Convert(reflect.TypeOf([]byte(nil))); err != nil || !reflect.DeepEqual(v, []byte("abc")) {
+		t.Errorf("Convert of 'Name' to []byte: got %v, %v", v, err)
+	}
+	if a.Count != 3 || a.Name != "abc" {
+		t.Errorf("Convert should not change the struct: got %+v", a)
+	}
+
+	if _, err := s.Field("Name").Convert(reflect.TypeOf(0)); err == nil {
+		t.Error("Convert of a string to int should fail")
+	}
+	if _, err := s.Field("Bytes").Convert(reflect.TypeOf([4]byte{})); err == nil {
+		t.Error("Convert of a slice to a longer array should fail")
+	}
+	if _, err := s.Field("Count").Convert(nil); err == nil {
+		t.Error("Convert to a nil type should fail")
+	}
+	if _, err := s.Field("private").Convert(float64Type); err != errNotExported {
+		t.Errorf("Convert of an unexported field: got %v, want %v", err, errNotExported)
+	}
+}
+
 func TestField_Name(t *testing.T) {
 	s := newStruct()
 
@@ -330,6 +1165,37 @@ func TestField_Name(t *testing.T) {
 	}
 }
 
+func TestField_IndexPath(t *testing.T) {
+	s := newStruct()
+
+	if got := s.Field("B").IndexPath(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("IndexPath of 'B': got %v, want [1]", got)
+	}
+
+	if got := s.Fields()[1].IndexPath(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("IndexPath of Fields()[1]: got %v, want [1]", got)
+	}
+
+	// F is promoted from the embedded *Bar
+	promoted := s.Field("F").IndexPath()
+	if !reflect.DeepEqual(promoted, []int{7, 1}) {
+		t.Errorf("IndexPath of promoted 'F': got %v, want [7 1]", promoted)
+	}
+	if v := s.value.FieldByIndex(promoted).Interface(); v != 2 {
+		t.Errorf("FieldByIndex(IndexPath) of 'F': got %v, want 2", v)
+	}
+
+	if got := s.Field("Bar").Field("F").IndexPath(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("IndexPath of 'Bar.F': got %v, want [1]", got)
+	}
+
+	path := s.Field("F").IndexPath()
+	path[0] = 0
+	if got := s.Field("F").IndexPath(); got[0] != 7 {
+		t.Error("IndexPath should return a copy")
+	}
+}
+
 func TestField_Field(t *testing.T) {
 	s := newStruct()
 
@@ -356,6 +1222,50 @@ func TestField_Field(t *testing.T) {
 	}
 }
 
+func TestField_FieldByPath(t *testing.T) {
+	s := newStruct()
+
+	f, ok := s.FieldByPath("Bar.E")
+	if !ok {
+		t.Fatal("The field 'Bar.E' should exist.")
+	}
+	if val := f.Value().(string); val != "example" {
+		t.Errorf("The value of field 'Bar.E' should be 'example', got: %s", val)
+	}
+
+	if err := f.Set("changed"); err != nil {
+		t.Error(err)
+	}
+	if val := s.Field("Bar").Field("E").Value().(string); val != "changed" {
+		t.Errorf("The value of field 'Bar.E' should be 'changed', got: %s", val)
+	}
+
+	f, ok = s.FieldByPath("A")
+	if !ok || f.Name() != "A" {
+		t.Error("The top-level field 'A' should exist.")
+	}
+
+	for _, path := range []string{"", "no-field", "Bar.e", "A.B", "E.A", "Bar.E.F"} {
+		if f, ok := s.FieldByPath(path); ok || f != nil {
+			t.Errorf("The path %q should not be found", path)
+		}
+	}
+
+	// F is promoted through a nil *Bar
+	if f, ok := New(&Foo{}).FieldByPath("F"); ok || f != nil {
+		t.Errorf("FieldByPath through a nil embedded pointer should fail, got %v, %t", f, ok)
+	}
+
+	s.Field("E").Set(&Baz{A: "baz"})
+	f, ok = s.FieldByPath("E.A")
+	if !ok {
+		t.Fatal("The field 'E.A' should exist after E is set.")
+	}
+	if val := f.Value().(string); val != "baz" {
+		t.Errorf("The value of field 'E.A' should be 'baz', got: %s", val)
+	}
+}
+
 func TestField_Fields(t *testing.T) {
 	s := newStruct()
 	fields := s.Field("Bar").Fields()
@@ -364,3 +1274,392 @@ func TestField_Fields(t *testing.T) {
 		t.Errorf("We expect 3 fields in embedded struct, was: %d", len(fields))
 	}
 }
+
+func TestField_StructValue(t *testing.T) {
+	s := newStruct()
+
+	bar, ok := s.Field("Bar").StructValue()
+	if !ok {
+		t.Fatal("StructValue of the embedded *Bar should succeed")
+	}
+	if err := bar.Field("E").Set("changed"); err != nil {
+		t.Fatalf("Set through StructValue: %v", err)
+	}
+	if v := s.Field("Bar").Field("E").Value(); v != "changed" {
+		t.Errorf("StructValue should wrap the nested struct itself, got E %v", v)
+	}
+	if bar.TagName != s.TagName {
+		t.Errorf("StructValue should keep the options, got TagName %q", bar.TagName)
+	}
+
+	type A struct {
+		Nested Baz
+		Nil    *Baz
+		Name   string
+		baz    Baz
+	}
+	a := New(&A{Nested: Baz{A: "a"}})
+
+	if n, ok := a.Field("Nested").StructValue(); !ok || n.Field("A").Value() != "a" {
+		t.Errorf("StructValue of a struct field: got %v, %t", n, ok)
+	}
+	for _, name := range []string{"Nil", "Name", "baz"} {
+		if n, ok := a.Field(name).StructValue(); ok || n != nil {
+			t.Errorf("StructValue of %q should fail", name)
+		}
+	}
+}
+
+func TestField_Owner(t *testing.T) {
+	s := newStruct()
+
+	if owner := s.Field("A").Owner(); owner != s {
+		t.Errorf("Owner of a top level field should be the struct itself, got: %v", owner)
+	}
+
+	for _, f := range s.Fields() {
+		if f.Owner() != s {
+			t.Errorf("Owner of field %s should be the struct itself", f.Name())
+		}
+	}
+
+	f := s.Field("Bar").Field("E")
+	owner := f.Owner()
+	if owner == nil {
+		t.Fatal("Owner of a nested field should not be nil")
+	}
+	if owner.Name() != "Bar" {
+		t.Errorf("Owner of a nested field should be the nested struct, got: %s", owner.Name())
+	}
+	if !owner.HasField("F") {
+		t.Error("Owner of a nested field should have the sibling fields")
+	}
+
+	// the owner writes through to the same struct
+	if err := owner.Field("F").Set(5); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Field("Bar").Field("F").Value(); got != 5 {
+		t.Errorf("Setting a field through the owner: got %v, want 5", got)
+	}
+
+	for _, nested := range s.Field("Bar").Fields() {
+		if nested.Owner().Name() != "Bar" {
+			t.Errorf("Owner of nested field %s should be Bar, got: %s", nested.Name(), nested.Owner().Name())
+		}
+	}
+
+	if p, ok := s.FieldByPath("Bar.E"); !ok || p.Owner().Name() != "Bar" {
+		t.Errorf("Owner of a field returned by FieldByPath should be the nested struct")
+	}
+}
+
+func TestField_Equal(t *testing.T) {
+	a := newStruct()
+	b := newStruct()
+
+	if !a.Field("A").Equal(b.Field("A")) {
+		t.Error("Equal fields should be equal")
+	}
+
+	if err := b.Field("A").Set("other"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Field("A").Equal(b.Field("A")) {
+		t.Error("Fields with different values should not be equal")
+	}
+
+	// both are unexported, it can't tell
+	if !a.Field("d").Equal(b.Field("d")) {
+		t.Error("Unexported fields: got false, want true")
+	}
+
+	if a.Field("d").Equal(b.Field("A")) || a.Field("A").Equal(b.Field("d")) {
+		t.Error("An unexported field should not be equal to an exported one")
+	}
+
+	// same value, different types
+	type T struct {
+		A string
+		B interface{}
+	}
+	c := New(&T{A: "x", B: "x"})
+	if c.Field("A").Equal(c.Field("B")) {
+		t.Error("Fields of different types should not be equal")
+	}
+}
+
+func TestField_CanSet(t *testing.T) {
+	s := newStruct()
+
+	if !s.Field("A").CanSet() {
+		t.Error("Exported field of a struct passed by pointer: got false, want true")
+	}
+	if s.Field("d").CanSet() {
+		t.Error("Unexported field: got true, want false")
+	}
+
+	v := New(Baz{A: "a"})
+	if v.Field("A").CanSet() {
+		t.Error("Field of a struct passed by value: got true, want false")
+	}
+
+	// Set agrees with CanSet
+	if err := s.Field("A").Set("gopher"); err != nil {
+		t.Errorf("Set should not fail when CanSet is true: %s", err)
+	}
+	if err := v.Field("A").Set("gopher"); err == nil {
+		t.Error("Set should fail when CanSet is false")
+	}
+}
+
+func TestField_FollowInterfaces(t *testing.T) {
+	type Plugin struct {
+		Name string
+		Opts struct {
+			Level int
+		}
+	}
+	type Config struct {
+		Plugin interface{}
+		Other  interface{}
+		Nil    interface{}
+	}
+
+	c := &Config{Plugin: &Plugin{Name: "gzip"}, Other: 42}
+	s := New(c)
+	s.FollowInterfaces = true
+
+	fields := s.Field("Plugin").Fields()
+	if len(fields) != 2 || fields[0].Name() != "Name" {
+		t.Fatalf("Fields of an interface holding a struct: got %v", fields)
+	}
+
+	if err := s.Field("Plugin").Field("Name").Set("zstd"); err != nil {
+		t.Fatal(err)
+	}
+	if p := c.Plugin.(*Plugin); p.Name != "zstd" {
+		t.Errorf("Setting a field through an interface: got %q, want %q", p.Name, "zstd")
+	}
+
+	if f, ok := s.FieldByPath("Plugin.Opts.Level"); !ok || f.Name() != "Level" {
+		t.Error("FieldByPath should descend into interfaces with FollowInterfaces")
+	}
+
+	if fields := s.Field("Nil").Fields(); fields != nil {
+		t.Errorf("Fields of a nil interface: got %v, want nil", fields)
+	}
+	if fields := s.Field("Other").Fields(); fields != nil {
+		t.Errorf("Fields of an interface holding an int: got %v, want nil", fields)
+	}
+
+	var paths []string
+	err := s.Walk(func(path []string, f *Field) error {
+		paths = append(paths, strings.Join(path, "."))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Plugin", "Plugin.Name", "Plugin.Opts", "Plugin.Opts.Level", "Other", "Nil"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Walk with FollowInterfaces: got %v, want %v", paths, want)
+	}
+
+	s.FollowInterfaces = false
+	if _, ok := s.FieldByPath("Plugin.Name"); ok {
+		t.Error("FieldByPath should not descend into interfaces without FollowInterfaces")
+	}
+}
+
+func TestField_Addr(t *testing.T) {
+	s := newStruct()
+
+	p, err := s.Field("A").Addr()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp, ok := p.(*string)
+	if !ok {
+		t.Fatalf("Addr of a string field: got %T, want *string", p)
+	}
+	*sp = "through pointer"
+	if got := s.Field("A").Value(); got != "through pointer" {
+		t.Errorf("Setting through Addr: got %v, want %q", got, "through pointer")
+	}
+
+	if _, err := s.Field("d").Addr(); err != errNotExported {
+		t.Errorf("Addr of an unexported field: got %v, want %v", err, errNotExported)
+	}
+
+	if _, err := New(Baz{}).Field("A").Addr(); err != errPassedByValue {
+		t.Errorf("Addr of a field of a struct passed by value: got %v, want %v", err, errPassedByValue)
+	}
+}
+
+func TestField_ReadOnly(t *testing.T) {
+	type T struct {
+		ID    int            `structs:"id,readonly"`
+		Name  string         `structs:"name"`
+		Tags  []string       `structs:"tags,readonly"`
+		Attrs map[string]int `structs:",readonly"`
+	}
+
+	v := &T{ID: 1, Name: "a"}
+	s := New(v)
+
+	id := s.Field("ID")
+	if id.CanSet() {
+		t.Error("CanSet of a read only field: got true, want false")
+	}
+	for name, err := range map[string]error{
+		"Set":           id.Set(2),
+		"Zero":          id.Zero(),
+		"SetFromString": id.SetFromString("2"),
+		"Append":        s.Field("Tags").Append("x"),
+		"SetSlice":      s.Field("Tags").SetSlice("x,y", ","),
+		"SetMapIndex":   s.Field("Attrs").SetMapIndex("x", 1),
+	} {
+		if err != errReadOnly {
+			t.Errorf("%s of a read only field: got %v, want %v", name, err, errReadOnly)
+		}
+	}
+
+	if err := s.Apply(map[string]interface{}{"name": "b", "id": 2}); err == nil {
+		t.Error("Apply should refuse read only fields")
+	}
+	if err := s.FillStruct(map[string]interface{}{"id": 2}); err == nil {
+		t.Error("FillStruct should refuse read only fields")
+	}
+	if err := s.DecodeMap(map[string]interface{}{"id": float64(2)}); err == nil {
+		t.Error("DecodeMap should refuse read only fields")
+	}
+	if want := (T{ID: 1, Name: "a"}); !reflect.DeepEqual(*v, want) {
+		t.Errorf("Read only fields should be left unchanged: got %+v, want %+v", *v, want)
+	}
+
+	// bulk setters skip read only fields but set the others
+	if err := s.SetAll(T{ID: 9, Name: "c"}); err != nil || v.ID != 1 || v.Name != "c" {
+		t.Errorf("SetAll should skip read only fields: got %+v, %v", *v, err)
+	}
+	if err := s.Merge(T{ID: 9, Name: "d", Tags: []string{"x"}}); err != nil || v.ID != 1 || v.Name != "d" || v.Tags != nil {
+		t.Errorf("Merge should skip read only fields: got %+v, %v", *v, err)
+	}
+	if err := s.ZeroFields(); err != nil || v.ID != 1 || v.Name != "" {
+		t.Errorf("ZeroFields should skip read only fields: got %+v, %v", *v, err)
+	}
+	if err := s.ZeroFields("ID"); err == nil || v.ID != 1 {
+		t.Errorf("ZeroFields of a named read only field should fail, got %+v, %v", *v, err)
+	}
+
+	// the fields of a read only struct are not zeroed one by one either
+	type Inner struct{ A int }
+	nested := &struct {
+		Inner Inner `structs:",readonly"`
+	}{Inner{A: 1}}
+	if err := New(nested).Field("Inner").ZeroNested(); err != errReadOnly || nested.Inner.A != 1 {
+		t.Errorf("ZeroNested of a read only struct: got %+v, %v, want %v", nested.Inner, err, errReadOnly)
+	}
+	v.Name = "a"
+
+	// reads are not affected
+	if m := s.Map(); m["id"] != 1 {
+		t.Errorf("Map should include read only fields, got %v", m)
+	}
+	if err := s.FillStruct(map[string]interface{}{"name": "b"}); err != nil || v.Name != "b" {
+		t.Errorf("FillStruct of other fields should work, got %v", err)
+	}
+}
+
+func TestField_SetIfZero(t *testing.T) {
+	s := newStruct()
+
+	// A is "gopher"
+	set, err := s.Field("A").SetIfZero("other")
+	if err != nil || set {
+		t.Errorf("SetIfZero of a non zero field: got %v, %v, want false, nil", set, err)
+	}
+	if got := s.Field("A").Value(); got != "gopher" {
+		t.Errorf("SetIfZero should not overwrite a non zero field, got %v", got)
+	}
+
+	// B is not initialized
+	set, err = s.Field("B").SetIfZero(42)
+	if err != nil || !set {
+		t.Errorf("SetIfZero of a zero field: got %v, %v, want true, nil", set, err)
+	}
+	if got := s.Field("B").Value(); got != 42 {
+		t.Errorf("SetIfZero of a zero field: got %v, want 42", got)
+	}
+
+	// a zero time in another location is still a zero time
+	created := struct{ Created time.Time }{time.Time{}.In(time.FixedZone("CET", 3600))}
+	now := time.Now()
+	set, err = New(&created).Field("Created").SetIfZero(now)
+	if err != nil || !set || !created.Created.Equal(now) {
+		t.Errorf("SetIfZero of a zero time: got %v, %v, %v, want true, nil, %v", set, err, created.Created, now)
+	}
+
+	if _, err := s.Field("E").SetIfZero("not a *Baz"); err == nil {
+		t.Error("SetIfZero with a wrong type should fail")
+	}
+	if _, err := s.Field("d").SetIfZero("x"); err != errNotExported {
+		t.Errorf("SetIfZero of an unexported field: got %v, want %v", err, errNotExported)
+	}
+	if _, err := New(Baz{A: "a"}).Field("A").SetIfZero("b"); err != errPassedByValue {
+		t.Errorf("SetIfZero of a struct passed by value: got %v, want %v", err, errPassedByValue)
+	}
+}
+
+func TestField_ElemKind(t *testing.T) {
+	type T struct {
+		Slice  []string
+		Array  [2]int
+		Map    map[int]bool
+		Chan   chan float64
+		Ptr    *Baz
+		Slices *[]int
+		Maps   *map[string]bool
+		Scalar int
+		hidden []uint8
+	}
+
+	s := New(&T{Slices: &[]int{}, Maps: &map[string]bool{}})
+
+	for _, tt := range []struct {
+		name string
+		kind reflect.Kind
+		ok   bool
+	}{
+		{"Slice", reflect.String, true},
+		{"Array", reflect.Int, true},
+		{"Map", reflect.Bool, true},
+		{"Chan", reflect.Float64, true},
+		{"Ptr", reflect.Struct, true},
+		{"Scalar", reflect.Invalid, false},
+		{"hidden", reflect.Uint8, true},
+	} {
+		kind, ok := s.Field(tt.name).ElemKind()
+		if kind != tt.kind || ok != tt.ok {
+			t.Errorf("ElemKind of %s: got %v, %v, want %v, %v", tt.name, kind, ok, tt.kind, tt.ok)
+		}
+	}
+
+	if kind, ok := s.Field("Map").MapKeyKind(); kind != reflect.Int || !ok {
+		t.Errorf("MapKeyKind of a map: got %v, %v, want int, true", kind, ok)
+	}
+	if kind, ok := s.Field("Slice").MapKeyKind(); kind != reflect.Invalid || ok {
+		t.Errorf("MapKeyKind of a slice: got %v, %v, want invalid, false", kind, ok)
+	}
+
+	// the Field returned by Elem has the type of the pointed value
+	slices, _ := s.Field("Slices").Elem()
+	if kind, ok := slices.ElemKind(); kind != reflect.Int || !ok {
+		t.Errorf("ElemKind of the Elem of a *[]int: got %v, %v, want int, true", kind, ok)
+	}
+	maps, _ := s.Field("Maps").Elem()
+	if kind, ok := maps.MapKeyKind(); kind != reflect.String || !ok {
+		t.Errorf("MapKeyKind of the Elem of a *map[string]bool: got %v, %v, want string, true", kind, ok)
+	}
+}
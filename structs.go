@@ -2,8 +2,22 @@
 package structs
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/url"
+	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -11,18 +25,137 @@ var (
 	// a more granular to tweak certain structs. Lookup the necessary functions
 	// for more info.
 	DefaultTagName = "structs" // struct's field default tag name
+
+	// SkipField is used as a return value from the function passed to Walk to
+	// indicate that the nested fields of the visited field are to be skipped.
+	// It is not returned as an error by Walk.
+	SkipField = errors.New("skip this field")
+
+	// Omit is used as a return value from the function passed to MapFunc to
+	// indicate that the field is to be omitted from the map.
+	Omit interface{} = omit{}
 )
 
+type omit struct{}
+
 // Struct encapsulates a struct type to provide several high level functions
 // around the struct.
 type Struct struct {
 	raw     interface{}
 	value   reflect.Value
 	TagName string
+
+	// TagNames, if not empty, are the tag keys read instead of TagName, in
+	// order of priority: every field is named, and its options are read,
+	// according to the first of them it has a tag for, ie: []string{"db",
+	// "json"} while migrating from json to db tags field by field.
+	TagNames []string
+
+	// TagOptionSep separates the name and the options in the field tags
+	// under TagName, ie: ";" for tags such as `structs:"name;omitempty"`. It
+	// defaults to a comma if empty.
+	TagOptionSep string
+
+	// OmitNested inverts the default handling of nested structs in Map. If
+	// true, nested structs are stored as they are, as if every field had the
+	// "omitnested" option, and only fields with the "recurse" option are
+	// processed further.
+	OmitNested bool
+
+	// NilEqualsZero makes Equal treat a nil pointer as equal to a pointer to
+	// a zero value.
+	NilEqualsZero bool
+
+	// DerefPointers makes Map store the value a non-nil pointer field points
+	// to instead of the pointer, ie: a string for a *string, and an untyped
+	// nil for a nil pointer, instead of a typed nil which json.Marshal and
+	// comparisons to nil treat differently. Pointers to structs are converted
+	// to maps as usual.
+	DerefPointers bool
+
+	// FollowInterfaces makes Field.Fields, Field.Field, FieldByPath and Walk
+	// descend into the struct held by a non-nil interface field, ie: a plugin
+	// config whose concrete type is chosen at runtime. Nil interfaces are
+	// skipped. Map always converts the struct an interface holds.
+	FollowInterfaces bool
+
+	// NestedSep, if not empty, makes Encode pass the fields of nested structs
+	// one by one, with their keys joined to the key of the struct by NestedSep.
+	NestedSep string
+
+	// Strict makes Unflatten fail on keys which don't resolve to a field
+	// instead of ignoring them.
+	Strict bool
+
+	// MaxDepth, if positive, limits the number of levels of nested structs
+	// converted to maps by Map, counting s itself as the first one. Nested
+	// structs beyond it, including the ones in slices and maps, are stored as
+	// they are. Zero means no limit.
+	MaxDepth int
+
+	// CopySlices makes Map and OrderedMap store copies of slice and map
+	// field values, including the slices and maps nested in them, instead of
+	// the values themselves, which share their backing arrays with the
+	// struct. This gives a snapshot unaffected by later changes to the
+	// struct, at the cost of the copies.
+	CopySlices bool
+
+	// RawDurations makes Map store time.Duration fields as they are instead
+	// of as strings such as "1h30m0s", which is how they are stored by
+	// default as their nanoseconds are unreadable in logs and dumps.
+	RawDurations bool
+
+	// IsEmptyFunc, if not nil, decides whether a field with the option of
+	// "omitempty" is empty and left out by Map and the other conversions
+	// honoring omitempty instead of Field.IsZero, ie: to also leave out
+	// empty but non-nil slices or blank strings. It's called with the field
+	// and its value.
+	IsEmptyFunc func(f *Field, v interface{}) bool
+
+	// LeafTypes are struct types which are stored as they are by Map instead
+	// of being converted to a map, and are treated as a single value by
+	// Values, IsZero, HasZero, Merge, Diff and URLValues. time.Time and the
+	// types registered with RegisterLeafType are always leaf types.
+	LeafTypes []reflect.Type
+
+	// Locker, if not nil, is locked while Map, OrderedMap, Keys, Values,
+	// Fields and Encode read the struct, for callers which already guard the
+	// struct with a mutex. It only guards the struct itself, not values it
+	// points to, and is not passed on to nested structs.
+	Locker sync.Locker
+
+	// NameFunc, if not nil, derives the key of a field in Map from the field
+	// name and the tag name, which is empty if the field has none, ie: to
+	// convert every key to snake_case.
+	NameFunc func(fieldName, tagName string) string
+
+	// mapFunc transforms the values stored by Map, see MapFunc.
+	mapFunc func(f *Field, v interface{}) interface{}
+
+	// filter selects the fields of s stored by Map, see Filter. Unlike the
+	// other options it is not passed on to nested structs.
+	filter func(f *Field) bool
+
+	// renamed maps field names of s to the keys stored by Map instead, see
+	// MapRenamed. Like filter it is not passed on to nested structs.
+	renamed map[string]string
+
+	// groups, if not nil, limits the fields stored by Map to the ones
+	// without a "groups" tag or sharing a group with it, see MapForGroups.
+	// Unlike filter it is passed on to nested structs.
+	groups map[string]bool
+
+	// depth is the level of s below the struct Map was called on, see
+	// MaxDepth.
+	depth int
+
+	// visiting contains the pointers to the structs being converted by Map
+	// from the outermost struct down to s, to break reference cycles.
+	visiting map[ptrKey]bool
 }
 
 // New returns a new *Struct with the struct s. It panics if the s's kind is
-// not struct.
+// not struct, see NewOk for a variant which doesn't.
 func New(s interface{}) *Struct {
 	return &Struct{
 		raw:     s,
@@ -31,6 +164,62 @@ func New(s interface{}) *Struct {
 	}
 }
 
+// NewOk is the same as New, except that it returns false instead of panicking
+// if s is not a struct or a non-nil pointer to one, ie: for values passed in
+// by callers. The package level functions, such as Map and Values, panic like
+// New, so NewOk(s) followed by the method of the same name is their non
+// panicking form. Example:
+//
+//	s, ok := structs.NewOk(v)
+//	if !ok {
+//		return errors.New("not a struct")
+//	}
+//	m := s.Map()
+func NewOk(s interface{}) (*Struct, bool) {
+	v := reflect.ValueOf(s)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	return New(s), true
+}
+
+// FromStruct copies the fields of the struct src into the struct dst points
+// to, which may be of a different type, as Scan does, and returns a new
+// *Struct with dst. It's a shorthand for adapting a struct between layers.
+// Example:
+//
+//	s, err := structs.FromStruct(&UserDTO{}, user)
+//
+// It returns an error if src is not a struct or a pointer to one, or if dst
+// is not a non-nil pointer to struct.
+func FromStruct(dst, src interface{}) (*Struct, error) {
+	from, ok := NewOk(src)
+	if !ok {
+		return nil, fmt.Errorf("can't copy from %T, need a struct", src)
+	}
+
+	if err := from.Scan(dst); err != nil {
+		return nil, err
+	}
+	return New(dst), nil
+}
+
+// WithTagName sets the TagName of s to tag and returns s, for chaining such as
+// New(s).WithTagName("db").Map(). It panics if tag is not a valid struct tag
+// key: empty, or containing a space, a quote, a colon or a control character.
+func (s *Struct) WithTagName(tag string) *Struct {
+	if !validTagKey(tag) {
+		panic(fmt.Sprintf("invalid tag name %q", tag))
+	}
+	s.TagName = tag
+	return s
+}
+
 // Map converts the given struct to a map[string]interface{}, where the keys
 // of the map are the field names and the values of the map the associated
 // values of the fields. The default key string is the struct field name but
@@ -48,15 +237,41 @@ func New(s interface{}) *Struct {
 // A tag value with the content of "string" uses the stringer to get the value. Example:
 //
 //	// The value will be output of Animal's String() func.
-//	// Map will panic if Animal does not implement String().
 //	Field *Animal `structs:"field,string"`
 //
+// Values of boolean, numeric and string kinds which don't implement String()
+// are formatted with strconv instead. Example:
+//
+//	// The value will be "42" instead of 42.
+//	ID int64 `structs:"id,string"`
+//
+// Fields of any other kind which don't implement String() are omitted.
+//
+// time.Duration fields are stored as strings, such as "1h30m0s", unless the
+// RawDurations field of s is true. FillStruct parses them back.
+//
 // A tag value with the option of "flatten" used in a struct field is to flatten its fields
 // in the output map. Example:
 //
 //	// The FieldStruct's fields will be flattened into the output map.
 //	FieldStruct time.Time `structs:",flatten"`
 //
+//	// The Inner's fields will be flattened into the output map, the name is
+//	// not used.
+//	Inner Inner `structs:"inner,flatten"`
+//
+// The option of "inline" is an alias of "flatten", as used by yaml and
+// mapstructure. Example:
+//
+//	// The Base's fields will be lifted into the output map.
+//	Base `structs:",inline"`
+//
+// If a flattened key collides with the key of a field of the struct itself,
+// the field of the struct itself wins regardless of the declaration order. If
+// the keys of several flattened fields collide, the last declared field wins.
+// A nil pointer to a flattened struct adds no keys. FillStruct follows the
+// same rules in reverse.
+//
 // A tag value with the option of "omitnested" stops iterating further if the type
 // is a struct. Example:
 //
@@ -65,7 +280,8 @@ func New(s interface{}) *Struct {
 //	Field *http.Request `structs:",omitnested"`
 //
 // A tag value with the option of "omitempty" ignores that particular field if
-// the field value is empty. Example:
+// the field value is empty. Values implementing an IsZero() bool method, such
+// as time.Time, are empty when that method says so. Example:
 //
 //	// Field appears in map as key "myName", but the field is
 //	// skipped if empty.
@@ -75,6 +291,28 @@ func New(s interface{}) *Struct {
 //	// the field is skipped if empty.
 //	Field string `structs:",omitempty"`
 //
+// If the NameFunc field of s is not nil, it is called to derive every key
+// from the field name and the tag name instead. Example:
+//
+//	s.NameFunc = func(fieldName, tagName string) string {
+//		if tagName != "" {
+//			return tagName
+//		}
+//		return strings.ToLower(fieldName)
+//	}
+//
+// If the OmitNested field of s is true, nested structs are not processed
+// further unless the field has the option of "recurse" (or "flatten" or
+// "inline").
+// Example:
+//
+//	// Field is converted to a map even though s.OmitNested is true.
+//	Field Server `structs:"server,recurse"`
+//
+// A pointer to a struct which is already being converted further up, ie: a
+// pointer back to a parent, is stored as it is instead of being converted
+// again, so reference cycles don't recurse forever.
+//
 // Note that only exported fields of a struct can be accessed, non exported
 // fields will be neglected.
 func (s *Struct) Map() map[string]interface{} {
@@ -90,291 +328,3059 @@ func (s *Struct) FillMap(out map[string]interface{}) {
 		return
 	}
 
-	fields := s.structFields()
+	defer s.lock()()
 
-	for _, field := range fields {
-		name := field.Name
-		val := s.value.FieldByName(name)
-		isSubStruct := false
-		var finalVal interface{}
+	s.fill(func(key string, val interface{}) {
+		out[key] = val
+	}, false)
+}
 
-		tagName, tagOpts := parseTag(field.Tag.Get(s.TagName))
-		if tagName != "" {
-			name = tagName
-		}
+// MapFunc is the same as Map, except that fn is called with every field and
+// the value Map would store for it, and the value returned by fn is stored
+// instead. The fields of nested structs are passed to fn as well, before the
+// nested map is passed with the field of the struct itself. If fn returns
+// Omit, the field is omitted from the map. Example:
+//
+//	// Format time.Time values as RFC3339 and omit the Password field.
+//	m := New(s).MapFunc(func(f *Field, v interface{}) interface{} {
+//		if f.Name() == "Password" {
+//			return Omit
+//		}
+//		if t, ok := v.(time.Time); ok {
+//			return t.Format(time.RFC3339)
+//		}
+//		return v
+//	})
+func (s *Struct) MapFunc(fn func(f *Field, v interface{}) interface{}) map[string]interface{} {
+	n := *s
+	n.mapFunc = fn
+	return n.Map()
+}
 
-		// if the value is a zero value and the field is marked as omitempty do
-		// not include
-		if tagOpts.Has("omitempty") {
-			zero := reflect.Zero(val.Type()).Interface()
-			current := val.Interface()
+// Filter is the same as Map, except that only the fields for which keep
+// returns true are stored in the map. The fields of nested structs are not
+// passed to keep, a nested struct is stored as a whole if its field is kept.
+// Example:
+//
+//	// Only keep the fields which have a "api" tag.
+//	m := New(s).Filter(func(f *Field) bool {
+//		return f.Tag("api") != ""
+//	})
+func (s *Struct) Filter(keep func(f *Field) bool) map[string]interface{} {
+	n := *s
+	n.filter = keep
+	return n.Map()
+}
 
-			if reflect.DeepEqual(current, zero) {
-				continue
-			}
-		}
+// MapRenamed is the same as Map, except that the fields whose names are in
+// overrides are stored under the associated keys instead of their usual ones.
+// Only the fields of s itself are renamed, not the fields of nested structs.
+// Example:
+//
+//	// The UserID field is stored as "uid" instead of "user_id".
+//	UserID int `structs:"user_id"`
+//	m := New(s).MapRenamed(map[string]string{"UserID": "uid"})
+func (s *Struct) MapRenamed(overrides map[string]string) map[string]interface{} {
+	n := *s
+	n.renamed = overrides
+	return n.Map()
+}
+
+// MapForGroups is the same as Map, except that a field with a "groups" tag,
+// listing comma separated group names, is only stored if it's in at least one
+// of the given groups, ie: to serialize a struct differently for different
+// audiences. Fields without a "groups" tag are always stored. This applies to
+// the fields of nested structs as well. Example:
+//
+//	// SSN is only stored by MapForGroups("admin").
+//	Name string `structs:"name"`
+//	SSN  string `structs:"ssn" groups:"admin,internal"`
+func (s *Struct) MapForGroups(groups ...string) map[string]interface{} {
+	n := *s
+	n.groups = make(map[string]bool, len(groups))
+	for _, group := range groups {
+		n.groups[group] = true
+	}
+	return n.Map()
+}
 
-		if !tagOpts.Has("omitnested") {
-			finalVal = s.nested(val)
+// inGroups returns true if field has no "groups" tag or shares a group with
+// the groups of s, or if s has no groups at all.
+func (s *Struct) inGroups(field structField) bool {
+	if s.groups == nil {
+		return true
+	}
 
-			v := reflect.ValueOf(val.Interface())
-			if v.Kind() == reflect.Ptr {
-				v = v.Elem()
-			}
+	tag, ok := field.Tag.Lookup("groups")
+	if !ok {
+		return true
+	}
 
-			switch v.Kind() {
-			case reflect.Map, reflect.Struct:
-				isSubStruct = true
-			}
-		} else {
-			finalVal = val.Interface()
+	for _, group := range strings.Split(tag, ",") {
+		if s.groups[strings.TrimSpace(group)] {
+			return true
 		}
+	}
+	return false
+}
 
-		if tagOpts.Has("string") {
-			s, ok := val.Interface().(fmt.Stringer)
-			if ok {
-				out[name] = s.String()
+// Select is the same as Map, except that only the fields with the given names
+// are stored in the map. A name is matched against the tag names first, and
+// against the field names if no tag name matches. Names that don't match any
+// field are ignored. Example:
+//
+//	// Only the "id" and "name" keys are stored, ie: for ?fields=id,name.
+//	m := New(s).Select("id", "name")
+func (s *Struct) Select(names ...string) map[string]interface{} {
+	fields := s.structFields()
+	selected := make(map[int]bool, len(names))
+
+	for _, name := range names {
+		found := false
+		for _, field := range fields {
+			if field.tagName == name {
+				selected[field.Index[0]] = true
+				found = true
 			}
+		}
+		if found {
 			continue
 		}
 
-		if isSubStruct && (tagOpts.Has("flatten")) {
-			for k := range finalVal.(map[string]interface{}) {
-				out[k] = finalVal.(map[string]interface{})[k]
+		for _, field := range fields {
+			if field.Name == name {
+				selected[field.Index[0]] = true
 			}
-		} else {
-			out[name] = finalVal
 		}
 	}
+
+	return s.Filter(func(f *Field) bool {
+		return selected[f.field.Index[0]]
+	})
 }
 
-// Values converts the given s struct's field values to a []interface{}.
-// A struct tag with the content of "-" ignores the that particular field.
-// Example:
-//
-//	// Field is ignored by this package.
-//	Field int `structs:"-"`
-//
-// A value with the option of "omitnested" stops iterating further if the type
-// is a struct. Example:
-//
-//	// Fields is not processed further by this package.
-//	Field time.Time     `structs:",omitnested"`
-//	Field *http.Request `structs:",omitnested"`
-//
-// A tag value with the option of "omitempty" ignores that particular field and
-// is not added to the values if the field value is empty. Example:
-//
-//	// Field is skipped if empty
-//	Field string `structs:",omitempty"`
+// MapExcept is the same as Map, except that the fields with the given names
+// are left out. A name is matched against the tag names first, and against the
+// field names if no tag name matches. The fields of nested structs are left
+// out with a dotted path of names, such as "Credentials.Secret". Names that
+// don't match any field are ignored. Example:
 //
-// Note that only exported fields of a struct can be accessed, non exported
-// fields will be neglected.
-func (s *Struct) Values() []interface{} {
-	fields := s.structFields()
+//	// The password and the token of the user are left out.
+//	m := New(user).MapExcept("password", "Auth.Token")
+func (s *Struct) MapExcept(names ...string) map[string]interface{} {
+	excluded := make(map[int]bool)
+	var paths [][]string
+
+	for _, name := range names {
+		path := strings.Split(name, ".")
+		if len(path) > 1 {
+			paths = append(paths, path)
+			continue
+		}
 
-	var t []interface{}
+		if field, ok := s.lookupField(s.value.Type(), name); ok {
+			excluded[field.Index[0]] = true
+		}
+	}
 
-	for _, field := range fields {
-		val := s.value.FieldByName(field.Name)
+	m := s.Filter(func(f *Field) bool {
+		return !excluded[f.field.Index[0]]
+	})
 
-		_, tagOpts := parseTag(field.Tag.Get(s.TagName))
+	for _, path := range paths {
+		s.deletePath(m, s.value.Type(), path)
+	}
 
-		// if the value is a zero value and the field is marked as omitempty do
-		// not include
-		if tagOpts.Has("omitempty") {
-			zero := reflect.Zero(val.Type()).Interface()
-			current := val.Interface()
+	return m
+}
 
-			if reflect.DeepEqual(current, zero) {
-				continue
-			}
-		}
+// deletePath deletes the field at path of the struct type t from m, the map
+// Map converted a value of t to.
+func (s *Struct) deletePath(m map[string]interface{}, t reflect.Type, path []string) {
+	field, ok := s.lookupField(t, path[0])
+	if !ok {
+		return
+	}
 
-		if tagOpts.Has("string") {
-			s, ok := val.Interface().(fmt.Stringer)
-			if ok {
-				t = append(t, s.String())
-			}
-			continue
-		}
+	key := s.key(field)
+	if len(path) == 1 {
+		delete(m, key)
+		return
+	}
 
-		if IsStruct(val.Interface()) && !tagOpts.Has("omitnested") {
-			// look out for embedded structs, and convert them to a
-			// []interface{} to be added to the final values slice
-			t = append(t, Values(val.Interface())...)
-		} else {
-			t = append(t, val.Interface())
+	nested, ok := m[key].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	ft := field.Type
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	if ft.Kind() == reflect.Struct {
+		s.deletePath(nested, ft, path[1:])
+	}
+}
+
+// lookupField returns the exported field of the struct type t whose tag name
+// is name or, failing that, whose field name is name.
+func (s *Struct) lookupField(t reflect.Type, name string) (structField, bool) {
+	fields := s.typeFields(t).exported
+
+	for _, field := range fields {
+		if field.tagName == name {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if field.Name == name {
+			return field, true
 		}
 	}
 
-	return t
+	return structField{}, false
 }
 
-// Fields returns a slice of Fields. A struct tag with the content of "-"
-// ignores the checking of that particular field. Example:
+// transform returns the value to store for the given field and its value v,
+// as returned by the mapFunc of s, or false if the field is to be omitted.
+func (s *Struct) transform(field structField, val reflect.Value, v interface{}) (interface{}, bool) {
+	if s.mapFunc == nil {
+		return v, true
+	}
+
+	v = s.mapFunc(s.newField(field, val), v)
+	return v, v != Omit
+}
+
+// ToJSON returns the JSON encoding of the map returned by Map, so that the
+// object keys and the omitted fields are driven by the struct's field tags
+// under TagName instead of the "json" tags. Values which are not converted by
+// Map, ie: fields with the option of "omitnested", are encoded by
+// encoding/json as usual.
+func (s *Struct) ToJSON() ([]byte, error) {
+	return json.Marshal(s.Map())
+}
+
+// DecodeJSON is the inverse of ToJSON. It decodes the JSON object data and
+// sets the fields of the struct from it as in DecodeMap, so the keys are
+// matched against the struct's field tags under TagName instead of the "json"
+// tags, and JSON numbers are converted to integer fields. Example:
 //
-//	// Field is ignored by this package.
-//	Field bool `structs:"-"`
+//	// Field is set from the "user_id" key of the JSON object.
+//	UserID int `structs:"user_id"`
 //
-// It panics if s's kind is not struct.
-func (s *Struct) Fields() []*Field {
-	return getFields(s.value, s.TagName)
+// It returns an error if data is not a valid JSON object or if DecodeMap
+// fails.
+func (s *Struct) DecodeJSON(data []byte) error {
+	var in map[string]interface{}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	return s.DecodeMap(in)
 }
 
-// Names returns a slice of field names. A struct tag with the content of "-"
-// ignores the checking of that particular field. Example:
-//
-//	// Field is ignored by this package.
-//	Field bool `structs:"-"`
+// Flatten is the same as Map, except that the result is a single level map:
+// the fields of nested structs are stored under dotted keys, such as
+// "server.tls.cert", and the elements of slices and arrays under indexed keys,
+// such as "servers[0].host". Empty nested structs, maps and slices, as well as
+// []byte values, are stored as they are. The keys of the fields of s itself are
+// the same as in Map. Example:
 //
-// It panics if s's kind is not struct.
-func (s *Struct) Names() []string {
-	fields := getFields(s.value, s.TagName)
+//	// Field appears as keys "server.host" and "server.ports[0]", etc.
+//	Field Server `structs:"server"`
+func (s *Struct) Flatten() map[string]interface{} {
+	return s.FlattenSep(".")
+}
 
-	names := make([]string, len(fields))
+// FlattenSep is the same as Flatten, except that the keys of nested fields are
+// joined with sep instead of a dot, ie: "_" for names of environment variables.
+func (s *Struct) FlattenSep(sep string) map[string]interface{} {
+	out := make(map[string]interface{})
+	for k, v := range s.Map() {
+		flattenValue(out, k, v, sep)
+	}
+	return out
+}
 
-	for i, field := range fields {
-		names[i] = field.Name()
+// flattenValue stores v, a value of the output of Map, in out under key, or
+// its nested values under keys prefixed with key.
+func flattenValue(out map[string]interface{}, key string, v interface{}, sep string) {
+	if m, ok := v.(map[string]interface{}); ok {
+		if len(m) == 0 {
+			out[key] = m
+			return
+		}
+		for k, elem := range m {
+			flattenValue(out, key+sep+k, elem, sep)
+		}
+		return
 	}
 
-	return names
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Len() == 0 || rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		for i := 0; i < rv.Len(); i++ {
+			flattenValue(out, fmt.Sprintf("%s[%d]", key, i), rv.Index(i).Interface(), sep)
+		}
+		return
+	}
+
+	out[key] = v
 }
 
-func getFields(v reflect.Value, tagName string) []*Field {
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
-	}
+// Unflatten is the inverse of Flatten. It sets the fields of the struct, and
+// of its nested structs, from the dotted and indexed keys of in, such as
+// "servers[0].host", with the same conversion rules as Field.Set. Nil pointers
+// are allocated and slices are grown as needed to reach a key. A nested map
+// value, ie: an empty struct stored by Flatten, is unflattened into its field.
+// The keys of maps with string keys are segments too, so that "servers.a.port"
+// sets the port of the "a" element of a map of structs, allocating the map and
+// the element as needed. Maps with other key types can't be reached. Keys
+// which don't resolve to a field are ignored, unless Strict is set.
+//
+// It returns an error if s is not settable (not created from a pointer), if a
+// value can't be assigned to its field, or if a key resolves to a field with
+// the option of "readonly".
+func (s *Struct) Unflatten(in map[string]interface{}) error {
+	return s.UnflattenSep(in, ".")
+}
 
-	t := v.Type()
+// UnflattenSep is the same as Unflatten, except that the keys of nested
+// fields are split on sep instead of a dot, see FlattenSep.
+func (s *Struct) UnflattenSep(in map[string]interface{}, sep string) error {
+	if err := settable(s.value); err != nil {
+		return err
+	}
 
-	var fields []*Field
+	// set the keys in a stable order, so the same key fails every time
+	keys := make([]string, 0, len(in))
+	for key := range in {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
+	for _, key := range keys {
+		segments := strings.Split(key, sep)
 
-		if tag := field.Tag.Get(tagName); tag == "-" {
+		// check the key first, so nothing is allocated for keys which don't
+		// resolve to a field
+		ok, err := s.checkFlat(segments)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if !ok {
+			if s.Strict {
+				return fmt.Errorf("%s: no such field", key)
+			}
 			continue
 		}
 
-		f := &Field{
-			field: field,
-			value: v.FieldByName(field.Name),
+		val := in[key]
+		err = s.setFlat(s.value, segments, func(v reflect.Value) error {
+			return s.assignFlat(v, val, sep)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
 		}
+	}
 
-		fields = append(fields, f)
+	return nil
+}
 
+// setFlat calls assign with the value the segments of a flattened key refer
+// to, starting from v, allocating pointers and maps and growing slices on the
+// way. Map elements aren't addressable, so they are assigned to a copy which
+// is stored back into the map afterwards. The segments must have been checked
+// with checkFlat.
+func (s *Struct) setFlat(v reflect.Value, segments []string, assign func(v reflect.Value) error) error {
+	if len(segments) == 0 {
+		return assign(v)
 	}
 
-	return fields
-}
+	name, indices, _ := parseFlatSegment(segments[0])
+	rest := segments[1:]
 
-// Field returns a new Field struct that provides several high level functions
-// around a single struct field entity or nil if the field was not found.
-func (s *Struct) Field(name string) *Field {
-	field, ok := s.value.Type().FieldByName(name)
-	if !ok {
-		return nil
+	v = allocIndirect(v)
+	if v.Kind() != reflect.Map {
+		field, _ := s.flatField(v.Type(), name)
+		return s.setFlatIndex(v.Field(field.Index[0]), indices, rest, assign)
 	}
-	return &Field{
-		field:      field,
-		value:      s.value.FieldByName(name),
-		defaultTag: s.TagName,
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	key := reflect.ValueOf(name).Convert(v.Type().Key())
+	elem := reflect.New(v.Type().Elem()).Elem()
+	if old := v.MapIndex(key); old.IsValid() {
+		elem.Set(old)
+	}
+	if err := s.setFlatIndex(elem, indices, rest, assign); err != nil {
+		return err
 	}
+	v.SetMapIndex(key, elem)
+	return nil
 }
 
-// IsZero returns true if all fields in a struct is a zero value (not
-// initialized) A struct tag with the content of "-" ignores the checking of
-// that particular field. Example:
-//
-//	// Field is ignored by this package.
-//	Field bool `structs:"-"`
-//
-// A value with the option of "omitnested" stops iterating further if the type
-// is a struct. Example:
-//
-//	// Field is not processed further by this package.
-//	Field time.Time     `structs:"myName,omitnested"`
-//	Field *http.Request `structs:",omitnested"`
-//
-// Note that only exported fields of a struct can be accessed, non exported
-// fields will be neglected. It panics if s's kind is not struct.
-func (s *Struct) IsZero() bool {
-	fields := s.structFields()
+// setFlatIndex is setFlat for the elements of v with the indices of a
+// segment, followed by the rest of the segments.
+func (s *Struct) setFlatIndex(v reflect.Value, indices []int, rest []string, assign func(v reflect.Value) error) error {
+	for _, i := range indices {
+		v = allocIndirect(v)
+		if v.Kind() == reflect.Slice && i >= v.Len() {
+			grow := reflect.MakeSlice(v.Type(), i+1-v.Len(), i+1-v.Len())
+			v.Set(reflect.AppendSlice(v, grow))
+		}
+		v = v.Index(i)
+	}
+	return s.setFlat(v, rest, assign)
+}
 
-	for _, field := range fields {
-		val := s.value.FieldByName(field.Name)
+// checkFlat returns true if the segments of a flattened key resolve to a field,
+// or to an element of a map with string keys, by the types of the fields only,
+// so that setFlat doesn't allocate anything for keys which don't.
+func (s *Struct) checkFlat(segments []string) (bool, error) {
+	t := s.value.Type()
 
-		_, tagOpts := parseTag(field.Tag.Get(s.TagName))
+	for _, segment := range segments {
+		name, indices, ok := parseFlatSegment(segment)
+		if !ok {
+			return false, nil
+		}
 
-		if IsStruct(val.Interface()) && !tagOpts.Has("omitnested") {
-			ok := IsZero(val.Interface())
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch {
+		case t.Kind() == reflect.Struct:
+			field, ok := s.flatField(t, name)
 			if !ok {
-				return false
+				return false, nil
 			}
+			if field.tagOpts.Has("readonly") {
+				return false, errReadOnly
+			}
+			t = field.Type
+		case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+			t = t.Elem()
+		default:
+			return false, nil
+		}
 
-			continue
+		for _, i := range indices {
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			switch t.Kind() {
+			case reflect.Slice:
+			case reflect.Array:
+				if i >= t.Len() {
+					return false, nil
+				}
+			default:
+				return false, nil
+			}
+			t = t.Elem()
 		}
+	}
 
-		// zero value of the given field, such as "" for string, 0 for int
-		zero := reflect.Zero(val.Type()).Interface()
+	return true, nil
+}
 
-		// current value of the given field
+// flatField returns the exported field of the struct type t with the given key
+// in Map.
+func (s *Struct) flatField(t reflect.Type, name string) (structField, bool) {
+	for _, field := range s.typeFields(t).exported {
+		if s.key(field) == name {
+			return field, true
+		}
+	}
+	return structField{}, false
+}
+
+// parseFlatSegment splits a segment of a flattened key, such as "servers[0]",
+// into the name and the indices. It returns false if the segment is malformed.
+func parseFlatSegment(segment string) (name string, indices []int, ok bool) {
+	i := strings.IndexByte(segment, '[')
+	if i < 0 {
+		return segment, nil, segment != ""
+	}
+
+	name, rest := segment[:i], segment[i:]
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return "", nil, false
+		}
+
+		n, err := strconv.Atoi(rest[1:end])
+		if err != nil || n < 0 {
+			return "", nil, false
+		}
+
+		indices = append(indices, n)
+		rest = rest[end+1:]
+	}
+
+	return name, indices, name != ""
+}
+
+// allocIndirect returns the value v points to, following pointers and
+// allocating the nil ones.
+func allocIndirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// assignFlat sets v, the value a flattened key refers to, to val.
+func (s *Struct) assignFlat(v reflect.Value, val interface{}, sep string) error {
+	if m, ok := val.(map[string]interface{}); ok && isStructType(v.Type()) {
+		return s.structFor(allocIndirect(v)).UnflattenSep(m, sep)
+	}
+
+	// durations are stored as strings by Map
+	if str, ok := val.(string); ok && v.Type() == durationType {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	converted, err := assignValue(val, v.Type())
+	if err != nil && val != nil && v.Kind() == reflect.Ptr {
+		// a value for a pointer, ie: a string for a *string
+		if elem, elemErr := assignValue(val, v.Type().Elem()); elemErr == nil {
+			allocIndirect(v).Set(elem)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	v.Set(converted)
+	return nil
+}
+
+// MapStringString is the same as Map, except that every value is converted to
+// a string, ie: for labels of metrics. Values are formatted with their
+// String() method if they implement fmt.Stringer, or with strconv for
+// boolean, numeric and string kinds. Slices, maps and any other values are
+// encoded with encoding/json, and nil pointers and interfaces become empty
+// strings. The fields of nested structs are flattened into dotted keys, such
+// as "server.host". Example:
+//
+//	// Field appears as keys "server.host" and "server.port".
+//	Field Server `structs:"server"`
+//
+// A tag value with the option of "omitnested" formats a nested struct as a
+// single value instead. The options "-" and "omitempty" are handled as in Map.
+func (s *Struct) MapStringString() map[string]string {
+	out := make(map[string]string)
+	s.mapStringString("", out, make(map[ptrKey]bool))
+	return out
+}
+
+// mapStringString adds the fields of s to out, prefixing their keys with
+// prefix. visiting contains the structs being converted from the outermost
+// one down to s, a struct reached again through a reference cycle is skipped.
+func (s *Struct) mapStringString(prefix string, out map[string]string, visiting map[ptrKey]bool) {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return
+	}
+	defer leave()
+
+	for _, field := range s.structFields() {
+		val := s.value.Field(field.Index[0])
+		name := prefix + s.key(field)
+
+		if field.tagOpts.Has("omitempty") && s.isEmpty(field, val) {
+			continue
+		}
+
+		if str, ok := stringValue(val); ok {
+			out[name] = str
+			continue
+		}
+
+		for (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && !val.IsNil() {
+			val = val.Elem()
+		}
+		if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+			out[name] = ""
+			continue
+		}
+
+		if val.Kind() == reflect.Struct && !s.isLeaf(val.Type()) && !field.tagOpts.Has("omitnested") &&
+			len(s.typeFields(val.Type()).exported) > 0 {
+			s.structFor(val).mapStringString(name+".", out, visiting)
+			continue
+		}
+
+		if str, ok := stringValue(val); ok {
+			out[name] = str
+			continue
+		}
+
+		data, err := json.Marshal(val.Interface())
+		if err != nil {
+			out[name] = fmt.Sprint(val.Interface())
+			continue
+		}
+		out[name] = string(data)
+	}
+}
+
+// URLValues converts the given struct to url.Values, ie: to build the body of
+// an application/x-www-form-urlencoded request. The keys are the same as in
+// Map, and the values are formatted with their String() method if they
+// implement fmt.Stringer, with strconv for boolean and numeric kinds, or with
+// fmt.Sprint otherwise. Slices and arrays are expanded into repeated keys, and
+// nil pointers are skipped. A []byte is added as a single string value. The
+// fields of nested structs and the entries of maps use bracketed keys, such as
+// "addr[city]". Example:
+//
+//	// Field appears as keys "addr[city]" and "addr[zip]".
+//	Field Address `structs:"addr"`
+//
+// A tag value with the option of "omitnested" formats a nested struct or map as
+// a single value instead. The options "-" and "omitempty" are handled as in
+// Map.
+func (s *Struct) URLValues() url.Values {
+	out := make(url.Values)
+	s.urlValues("", out, make(map[ptrKey]bool))
+	return out
+}
+
+// urlValues adds the form values of the fields of s to out, with their keys
+// bracketed and prefixed with prefix if it is not empty. visiting contains the
+// structs being converted from the outermost one down to s, a struct reached
+// again through a reference cycle is skipped.
+func (s *Struct) urlValues(prefix string, out url.Values, visiting map[ptrKey]bool) {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return
+	}
+	defer leave()
+
+	for _, field := range s.structFields() {
+		val := s.value.Field(field.Index[0])
+
+		name := s.key(field)
+		if prefix != "" {
+			name = prefix + "[" + name + "]"
+		}
+
+		if field.tagOpts.Has("omitempty") && s.isEmpty(field, val) {
+			continue
+		}
+
+		s.addURLValue(out, name, val, !field.tagOpts.Has("omitnested"), visiting)
+	}
+}
+
+// addURLValue adds the form values of val under key to out. If recurse is
+// true, nested structs and maps are expanded into bracketed keys, see
+// urlValues for visiting.
+func (s *Struct) addURLValue(out url.Values, key string, val reflect.Value, recurse bool, visiting map[ptrKey]bool) {
+	for {
+		if str, ok := val.Interface().(fmt.Stringer); ok && (val.Kind() != reflect.Ptr || !val.IsNil()) {
+			out.Add(key, str.String())
+			return
+		}
+
+		if val.Kind() != reflect.Ptr && val.Kind() != reflect.Interface {
+			break
+		}
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		if val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8 {
+			out.Add(key, string(val.Bytes()))
+			return
+		}
+		for i := 0; i < val.Len(); i++ {
+			s.addURLValue(out, key, val.Index(i), recurse, visiting)
+		}
+		return
+	case reflect.Struct:
+		if !recurse || s.isLeaf(val.Type()) {
+			break
+		}
+		n := s.structFor(val)
+		if len(n.structFields()) == 0 {
+			break
+		}
+		n.urlValues(key, out, visiting)
+		return
+	case reflect.Map:
+		if !recurse {
+			break
+		}
+		for _, k := range val.MapKeys() {
+			s.addURLValue(out, key+"["+mapKey(k)+"]", val.MapIndex(k), recurse, visiting)
+		}
+		return
+	}
+
+	if str, ok := stringValue(val); ok {
+		out.Add(key, str)
+		return
+	}
+	out.Add(key, fmt.Sprint(val.Interface()))
+}
+
+// KeyValue is a single key and value pair of the OrderedMap output.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// OrderedMap is the same as Map, except that it returns the keys and values in
+// the order the fields are declared in the struct. Nested structs appear as a
+// nested []KeyValue instead of a map[string]interface{}. A key that is set
+// more than once, ie: by a flattened field, keeps the position where it first
+// appeared.
+func (s *Struct) OrderedMap() []KeyValue {
+	defer s.lock()()
+
+	var out []KeyValue
+	index := make(map[string]int)
+
+	s.fill(func(key string, val interface{}) {
+		if i, ok := index[key]; ok {
+			out[i].Value = val
+			return
+		}
+		index[key] = len(out)
+		out = append(out, KeyValue{Key: key, Value: val})
+	}, true)
+
+	return out
+}
+
+// Encode calls enc with w, and the key and the value of every field the way
+// OrderedMap stores them, in declaration order, ie: to stream the fields of a
+// wide struct into an encoder without building the whole map first. A nested
+// struct is passed as a []KeyValue, or, if NestedSep is not empty, its fields
+// are passed one by one with their keys joined to the key of the struct by
+// NestedSep, such as "server.host". A key may be passed more than once if the
+// keys of flattened fields collide, the last one wins as in Map.
+//
+// It stops at the first non-nil error returned by enc and returns it. Example:
+//
+//	err := s.Encode(w, func(w io.Writer, key string, val interface{}) error {
+//		_, err := fmt.Fprintf(w, "%s=%v\n", key, val)
+//		return err
+//	})
+func (s *Struct) Encode(w io.Writer, enc func(w io.Writer, key string, val interface{}) error) error {
+	defer s.lock()()
+
+	var err error
+	s.fill(func(key string, val interface{}) {
+		if err == nil {
+			err = s.encodeValue(w, enc, key, val)
+		}
+	}, true)
+
+	return err
+}
+
+// encodeValue passes key and val to enc, or the values of val with their keys
+// joined to key if val is a nested struct and NestedSep is set.
+func (s *Struct) encodeValue(w io.Writer, enc func(w io.Writer, key string, val interface{}) error, key string, val interface{}) error {
+	kvs, ok := val.([]KeyValue)
+	if !ok || s.NestedSep == "" {
+		return enc(w, key, val)
+	}
+
+	for _, kv := range kvs {
+		if err := s.encodeValue(w, enc, key+s.NestedSep+kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys Map stores, in the order of OrderedMap. The same rules
+// as in Map apply, including the tag name, NameFunc, omitempty, flattening and
+// the options of s, so they can be used as column names or headers for the
+// values of Map. Example:
+//
+//	// Keys returns []string{"id", "name"} if Name is not empty.
+//	ID   int    `structs:"id"`
+//	Name string `structs:"name,omitempty"`
+//
+// The keys of nested structs are not included, so they don't line up with
+// Values. Use Columns for names matching Values.
+func (s *Struct) Keys() []string {
+	defer s.lock()()
+
+	var keys []string
+	seen := make(map[string]bool)
+
+	s.fill(func(key string, val interface{}) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}, true)
+
+	return keys
+}
+
+// fill calls put with the key and value of every field as described in Map.
+// If ordered is true nested structs are converted to a []KeyValue instead of a
+// map[string]interface{}.
+func (s *Struct) fill(put func(key string, val interface{}), ordered bool) {
+	fields := s.structFields()
+
+	// keys of the fields of s itself, which win over flattened keys
+	direct := make(map[string]bool)
+
+	for _, field := range fields {
+		name := s.key(field)
+		if key, ok := s.renamed[field.Name]; ok {
+			name = key
+		}
+		val := s.value.Field(field.Index[0])
+		isSubStruct := false
+		var finalVal interface{}
+
+		if s.filter != nil && !s.filter(s.newField(field, val)) {
+			continue
+		}
+
+		if !s.inGroups(field) {
+			continue
+		}
+
+		tagOpts := field.tagOpts
+
+		// if the value is a zero value and the field is marked as omitempty do
+		// not include
+		if tagOpts.Has("omitempty") && s.isEmpty(field, val) {
+			continue
+		}
+
+		// a nil pointer to an inlined struct has no keys to lift
+		if tagOpts.Inline() && val.Kind() == reflect.Ptr && val.IsNil() && isStructType(val.Type()) {
+			continue
+		}
+
+		// the field itself, val may be the value it points to from here on
+		fieldVal := val
+
+		if s.DerefPointers {
+			for val.Kind() == reflect.Ptr && !val.IsNil() && !isStructType(val.Type()) {
+				val = val.Elem()
+			}
+			if val.Kind() == reflect.Ptr && val.IsNil() {
+				if v, ok := s.transform(field, fieldVal, nil); ok {
+					direct[name] = true
+					put(name, v)
+				}
+				continue
+			}
+		}
+
+		recurse := !tagOpts.Has("omitnested")
+		if s.OmitNested {
+			recurse = tagOpts.Has("recurse") || tagOpts.Inline()
+		}
+
+		if recurse {
+			finalVal = s.nested(val, ordered)
+
+			v := reflect.ValueOf(val.Interface())
+			if v.Kind() == reflect.Ptr {
+				v = v.Elem()
+			}
+
+			switch v.Kind() {
+			case reflect.Map, reflect.Struct:
+				isSubStruct = true
+			}
+		} else {
+			finalVal = val.Interface()
+		}
+
+		if tagOpts.Has("string") {
+			if str, ok := stringValue(val); ok {
+				if v, ok := s.transform(field, fieldVal, str); ok {
+					direct[name] = true
+					put(name, v)
+				}
+			}
+			continue
+		}
+
+		if val.Type() == durationType && !s.RawDurations {
+			finalVal = val.Interface().(time.Duration).String()
+		}
+
+		// values which weren't converted still share their backing arrays
+		// with the struct
+		if s.CopySlices && finalVal != nil && reflect.TypeOf(finalVal) == val.Type() {
+			finalVal = copyContainers(val).Interface()
+		}
+
+		if isSubStruct && tagOpts.Inline() {
+			switch sub := finalVal.(type) {
+			case map[string]interface{}:
+				for k := range sub {
+					if !direct[k] {
+						put(k, sub[k])
+					}
+				}
+				continue
+			case []KeyValue:
+				for _, kv := range sub {
+					if !direct[kv.Key] {
+						put(kv.Key, kv.Value)
+					}
+				}
+				continue
+			}
+		}
+
+		if v, ok := s.transform(field, fieldVal, finalVal); ok {
+			direct[name] = true
+			put(name, v)
+		}
+	}
+}
+
+// FillStruct is the inverse of Map. It sets every exported field of the struct
+// whose key, as described in Map, is found in the given map to the associated
+// value, using the same conversion rules as Field.Set. A nested struct, or a
+// pointer to struct, is filled recursively from a nested
+// map[string]interface{}, allocating the pointer if it's nil. Slices, and maps
+// with string keys, are filled element by element from an []interface{} and a
+// map[string]interface{}, as Map stores slices and maps of structs. A nil value
+// sets the field to its zero value. Keys that don't match any field are
+// ignored. A struct tag with the content of "-" ignores that particular field.
+//
+// A struct field with the option of "flatten" or "inline" is filled from the
+// keys of in itself, except the keys of the other fields of the struct, which
+// win as they do in Map. A nil pointer to such a struct is only allocated if
+// any of its fields is set to a non-zero value.
+//
+// It returns an error if s is not settable (not created from a pointer), if
+// a value can't be assigned to its field, or if the key of a field with the
+// option of "readonly" is found in the map.
+func (s *Struct) FillStruct(in map[string]interface{}) error {
+	if err := settable(s.value); err != nil {
+		return err
+	}
+
+	fields := s.structFields()
+
+	// keys of the fields of s itself, which are not passed to inlined fields
+	direct := make(map[string]bool)
+	for _, field := range fields {
+		if !field.tagOpts.Inline() {
+			direct[s.key(field)] = true
+		}
+	}
+
+	for _, field := range fields {
+		name := s.key(field)
+
+		if field.tagOpts.Inline() && isStructType(field.Type) {
+			f := s.newField(field, s.value.Field(field.Index[0]))
+			if err := s.fillInline(f, in, direct, (*Struct).FillStruct); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			continue
+		}
+
+		val, ok := in[name]
+		if !ok {
+			continue
+		}
+
+		f := &Field{
+			field:      field.StructField,
+			value:      s.value.Field(field.Index[0]),
+			defaultTag: s.tagKey(),
+			owner:      s,
+		}
+
+		if err := s.fillField(f, val, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeMap is the same as FillStruct, including the handling of fields with
+// the option of "flatten" or "inline", except that it coerces the values of
+// the map, as returned by json.Unmarshal, to the types of the fields:
+//
+//   - a float64 is converted to any integer kind if it has no fractional part
+//     and fits into it
+//   - a string is parsed into a time.Time as RFC 3339, and into a
+//     time.Duration with time.ParseDuration
+//   - an []interface{} is decoded into a slice element by element
+//   - a map[string]interface{} is decoded into a struct, or into a map with
+//     string keys value by value
+//
+// It returns an error with the path of the offending value, ie:
+// "servers[1].port: can't assign string to int", if a value can't be decoded.
+func (s *Struct) DecodeMap(in map[string]interface{}) error {
+	if err := settable(s.value); err != nil {
+		return err
+	}
+	return s.decodeMap(in, "")
+}
+
+// decodeMap decodes in into the fields of s, with their keys appended to path
+// in errors.
+func (s *Struct) decodeMap(in map[string]interface{}, path string) error {
+	fields := s.structFields()
+
+	// keys of the fields of s itself, which are not passed to inlined fields
+	direct := make(map[string]bool)
+	for _, field := range fields {
+		if !field.tagOpts.Inline() {
+			direct[s.key(field)] = true
+		}
+	}
+
+	for _, field := range fields {
+		name := s.key(field)
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		// the fields of inlined structs are at the same level as the fields
+		// of s, so their paths don't include the inlined field
+		if field.tagOpts.Inline() && isStructType(field.Type) {
+			f := s.newField(field, s.value.Field(field.Index[0]))
+			err := s.fillInline(f, in, direct, func(n *Struct, in map[string]interface{}) error {
+				return n.decodeMap(in, path)
+			})
+			if err == errReadOnly {
+				return fmt.Errorf("%s: %w", fieldPath, err)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, ok := in[name]
+		if !ok {
+			continue
+		}
+
+		if field.tagOpts.Has("readonly") {
+			return fmt.Errorf("%s: %w", fieldPath, errReadOnly)
+		}
+
+		if err := s.decodeValue(s.value.Field(field.Index[0]), val, fieldPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeValue decodes val into v for DecodeMap.
+func (s *Struct) decodeValue(v reflect.Value, val interface{}, path string) error {
+	if val == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return s.decodeValue(v.Elem(), val, path)
+	}
+
+	if ok, err := setElems(v, val, path, s.decodeValue); ok {
+		return err
+	}
+
+	switch in := val.(type) {
+	case string:
+		switch v.Type() {
+		case timeType:
+			t, err := time.Parse(time.RFC3339, in)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		case durationType:
+			d, err := time.ParseDuration(in)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+	case map[string]interface{}:
+		if v.Kind() == reflect.Struct && v.Type() != timeType {
+			return s.structFor(v).decodeMap(in, path)
+		}
+	}
+
+	converted, err := assignValue(val, v.Type())
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	v.Set(converted)
+	return nil
+}
+
+// setElems sets v, a slice or a map with string keys, to the elements of val,
+// an []interface{} or a map[string]interface{}, calling set for every element
+// with its path. It returns false if val doesn't fit v that way.
+func setElems(v reflect.Value, val interface{}, path string, set func(v reflect.Value, val interface{}, path string) error) (bool, error) {
+	switch in := val.(type) {
+	case []interface{}:
+		if v.Kind() != reflect.Slice {
+			return false, nil
+		}
+		slice := reflect.MakeSlice(v.Type(), len(in), len(in))
+		for i, elem := range in {
+			if err := set(slice.Index(i), elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return true, err
+			}
+		}
+		v.Set(slice)
+		return true, nil
+	case map[string]interface{}:
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return false, nil
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(in))
+		for k, elem := range in {
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := set(e, elem, path+"."+k); err != nil {
+				return true, err
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), e)
+		}
+		v.Set(m)
+		return true, nil
+	}
+	return false, nil
+}
+
+// isStructType returns true if t is a struct or a pointer to struct.
+func isStructType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// fillInline fills the inlined struct field f from the keys of in which are not
+// in direct, calling fill with a Struct wrapping it, ie: FillStruct.
+func (s *Struct) fillInline(f *Field, in map[string]interface{}, direct map[string]bool, fill func(n *Struct, in map[string]interface{}) error) error {
+	if f.readOnly() {
+		return errReadOnly
+	}
+
+	rest := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		if !direct[k] {
+			rest[k] = v
+		}
+	}
+
+	v := f.value
+	if v.Kind() != reflect.Ptr {
+		return fill(s.structFor(v), rest)
+	}
+
+	if !v.IsNil() {
+		return fill(s.structFor(v.Elem()), rest)
+	}
+
+	// fill a new struct and only keep it if anything was set
+	p := reflect.New(v.Type().Elem())
+	if err := fill(s.structFor(p.Elem()), rest); err != nil {
+		return err
+	}
+	if isZeroValue(p.Elem()) {
+		return nil
+	}
+	if err := settable(v); err != nil {
+		return err
+	}
+	v.Set(p)
+	return nil
+}
+
+// fillField sets the field f of s to val for FillStruct, descending into
+// nested structs if val is a map[string]interface{}, and into slices and maps
+// element by element. Errors are prefixed with name, or with the path of the
+// element in the field.
+func (s *Struct) fillField(f *Field, val interface{}, name string) error {
+	if f.readOnly() {
+		return fmt.Errorf("%s: %w", name, errReadOnly)
+	}
+
+	// slices and maps of structs are stored as an []interface{} and a
+	// map[string]interface{} by Map
+	if ok, err := setElems(f.value, val, name, s.fillElem); ok {
+		return err
+	}
+
+	if err := s.fillValue(f, val); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// fillValue sets the field f of s to val for fillField.
+func (s *Struct) fillValue(f *Field, val interface{}) error {
+	if val == nil {
+		return f.Zero()
+	}
+
+	// durations are stored as strings by Map
+	if str, ok := val.(string); ok && f.value.Type() == durationType {
+		return f.SetFromString(str)
+	}
+
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return f.Set(val)
+	}
+
+	v := f.value
+	if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct {
+		if v.IsNil() {
+			if err := settable(f.value); err != nil {
+				return err
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return f.Set(val)
+	}
+
+	n := *s
+	n.raw = v.Interface()
+	n.value = v
+	return n.FillStruct(m)
+}
+
+// fillElem sets the element v of a slice or a map to val for fillField, with
+// the same rules as fillValue, and prefixes errors with path.
+func (s *Struct) fillElem(v reflect.Value, val interface{}, path string) error {
+	// the element is already a zero value
+	if val == nil {
+		return nil
+	}
+
+	if ok, err := setElems(v, val, path, s.fillElem); ok {
+		return err
+	}
+
+	if m, ok := val.(map[string]interface{}); ok && isStructType(v.Type()) && !s.isLeaf(v.Type()) {
+		if v.Kind() == reflect.Ptr {
+			v.Set(reflect.New(v.Type().Elem()))
+			v = v.Elem()
+		}
+		if err := s.structFor(v).FillStruct(m); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+
+	if str, ok := val.(string); ok && v.Type() == durationType {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	converted, err := assignValue(val, v.Type())
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	v.Set(converted)
+	return nil
+}
+
+// SetDefaults sets every exported field of the struct which is a zero value to
+// the value of its "default" tag, parsed as in Field.SetFromString. Fields
+// which are not zero are left as they are, so values set before win. Fields of
+// nested structs, and of non-nil pointers to structs, get their defaults
+// recursively. Example:
+//
+//	// Field is set to 8080 if it's zero.
+//	Port int `structs:"port" default:"8080"`
+//
+// A struct tag with the content of "-" ignores that particular field. It
+// returns an error listing every field that couldn't be set.
+func (s *Struct) SetDefaults() error {
+	if err := settable(s.value); err != nil {
+		return err
+	}
+
+	var errs multiError
+	s.setDefaults("", &errs, make(map[ptrKey]bool))
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setDefaults sets the defaults of the fields of s, prefixing their names with
+// prefix in errors. visiting contains the structs being set from the outermost
+// one down to s, a struct reached again through a reference cycle is skipped.
+func (s *Struct) setDefaults(prefix string, errs *multiError, visiting map[ptrKey]bool) {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return
+	}
+	defer leave()
+
+	for _, field := range s.structFields() {
+		name := prefix + field.Name
+		val := s.value.Field(field.Index[0])
+
+		nested := val
+		if nested.Kind() == reflect.Ptr && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && !s.isLeaf(nested.Type()) {
+			s.structFor(nested).setDefaults(name+".", errs, visiting)
+			continue
+		}
+
+		def, ok := field.Tag.Lookup("default")
+		if !ok || !isZeroValue(val) {
+			continue
+		}
+
+		if err := s.newField(field, val).SetFromString(def); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+}
+
+// Validate returns an error listing every exported field with the option of
+// "required" which is still a zero value. Fields of nested structs, and of
+// non-nil pointers to structs, are validated recursively and reported with
+// their dotted path, such as "Database.Host". Example:
+//
+//	// Validate fails if Token is empty.
+//	Token string `structs:"token,required"`
+//
+// A struct tag with the content of "-" ignores that particular field.
+func (s *Struct) Validate() error {
+	var errs multiError
+	s.validate("", &errs, make(map[ptrKey]bool))
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate adds an error for every required field of s which is a zero value
+// to errs, prefixing their names with prefix. visiting contains the structs
+// being validated from the outermost one down to s, a struct reached again
+// through a reference cycle is skipped.
+func (s *Struct) validate(prefix string, errs *multiError, visiting map[ptrKey]bool) {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return
+	}
+	defer leave()
+
+	for _, field := range s.structFields() {
+		name := prefix + field.Name
+		val := s.value.Field(field.Index[0])
+
+		if field.tagOpts.Has("required") && isZeroValue(val) {
+			*errs = append(*errs, fmt.Errorf("%s: required field is not set", name))
+			continue
+		}
+
+		nested := val
+		if nested.Kind() == reflect.Ptr && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && !s.isLeaf(nested.Type()) {
+			s.structFor(nested).validate(name+".", errs, visiting)
+		}
+	}
+}
+
+// DecodeEnv sets the exported fields of the struct from environment variables,
+// parsing them as in Field.SetFromString. The name of the variable is prefix
+// followed by the tag name under the "env" key, or by the upper case field name
+// if there is none. Fields of nested structs, and of non-nil pointers to
+// structs, are set from variables whose prefix is extended by the name of the
+// nested struct and an underscore. Example:
+//
+//	// Field is set from $APP_PORT for the prefix "APP_".
+//	Port int `env:"PORT"`
+//
+//	// Fields are set from $APP_DB_HOST, etc. for the prefix "APP_".
+//	Database DB `env:"DB"`
+//
+// Fields whose variable is not set are left as they are, so defaults survive,
+// and a tag value with the content of "-" ignores that particular field. A tag
+// value with the option of "required" makes DecodeEnv fail if the variable is
+// not set. Example:
+//
+//	// Field must be set from $APP_TOKEN.
+//	Token string `env:"TOKEN,required"`
+//
+// It returns an error listing every field that couldn't be set.
+func (s *Struct) DecodeEnv(prefix string) error {
+	var errs multiError
+	s.decodeEnv(prefix, &errs, make(map[ptrKey]bool))
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// decodeEnv sets the fields of s from environment variables with the given
+// prefix, adding any errors to errs. visiting contains the structs being set
+// from the outermost one down to s, a struct reached again through a reference
+// cycle is skipped.
+func (s *Struct) decodeEnv(prefix string, errs *multiError, visiting map[ptrKey]bool) {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return
+	}
+	defer leave()
+
+	for _, field := range cachedFields(s.value.Type(), "env").exported {
+		name := field.tagName
+		if name == "" {
+			name = strings.ToUpper(field.Name)
+		}
+		name = prefix + name
+
+		val := s.value.Field(field.Index[0])
+
+		nested := val
+		if nested.Kind() == reflect.Ptr && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && len(cachedFields(nested.Type(), "env").exported) > 0 {
+			n := *s
+			n.raw = nested.Interface()
+			n.value = nested
+			n.decodeEnv(name+"_", errs, visiting)
+			continue
+		}
+
+		str, ok := os.LookupEnv(name)
+		if !ok {
+			if field.tagOpts.Has("required") {
+				*errs = append(*errs, fmt.Errorf("%s: required environment variable is not set", name))
+			}
+			continue
+		}
+
+		f := &Field{
+			field:      field.StructField,
+			value:      val,
+			defaultTag: s.tagKey(),
+			owner:      s,
+		}
+		if err := f.SetFromString(str); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+}
+
+// Values converts the given s struct's field values to a []interface{}.
+// A struct tag with the content of "-" ignores the that particular field.
+// Example:
+//
+//	// Field is ignored by this package.
+//	Field int `structs:"-"`
+//
+// A value with the option of "omitnested" stops iterating further if the type
+// is a struct. Example:
+//
+//	// Fields is not processed further by this package.
+//	Field time.Time     `structs:",omitnested"`
+//	Field *http.Request `structs:",omitnested"`
+//
+// A tag value with the option of "omitempty" ignores that particular field and
+// is not added to the values if the field value is empty. Example:
+//
+//	// Field is skipped if empty
+//	Field string `structs:",omitempty"`
+//
+// The values are in declaration order, with the values of nested structs in
+// place of the struct, and line up with the names returned by Columns.
+//
+// Note that only exported fields of a struct can be accessed, non exported
+// fields will be neglected.
+func (s *Struct) Values() []interface{} {
+	defer s.lock()()
+
+	var t []interface{}
+	s.columns("", func(name string, v interface{}) {
+		t = append(t, v)
+	}, make(map[ptrKey]bool))
+	return t
+}
+
+// Columns returns the values of Values along with a name for each of them, so
+// that both can be used as the header and a row of a CSV file without ever
+// getting out of line. A name is the key of the field as in Map, prefixed with
+// the keys of the enclosing fields for the values of nested structs, ie:
+// "server.host", except for the fields of structs with the option of "inline",
+// which Map lifts as well. Example:
+//
+//	// Columns returns []string{"id", "server.host"} and the values.
+//	ID     int    `structs:"id"`
+//	Server Server `structs:"server"`
+func (s *Struct) Columns() (names []string, values []interface{}) {
+	defer s.lock()()
+
+	s.columns("", func(name string, v interface{}) {
+		names = append(names, name)
+		values = append(values, v)
+	}, make(map[ptrKey]bool))
+	return names, values
+}
+
+// columns calls add with the name and value of every value of Values, in
+// order, prefixing the names with prefix. Structs already being visited are
+// skipped, so that reference cycles end.
+func (s *Struct) columns(prefix string, add func(name string, v interface{}), visiting map[ptrKey]bool) {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return
+	}
+	defer leave()
+
+	for _, field := range s.structFields() {
+		name := prefix + s.key(field)
+		val := s.value.Field(field.Index[0])
+
+		tagOpts := field.tagOpts
+
+		// if the value is a zero value and the field is marked as omitempty do
+		// not include
+		if tagOpts.Has("omitempty") && s.isEmpty(field, val) {
+			continue
+		}
+
+		if tagOpts.Has("string") {
+			if str, ok := stringValue(val); ok {
+				add(name, str)
+			}
+			continue
+		}
+
+		if IsStruct(val.Interface()) && !s.isLeaf(val.Type()) && !tagOpts.Has("omitnested") {
+			// look out for embedded structs, and add their values to the
+			// final values one by one, lifting the ones of inline structs
+			nested := prefix
+			if !tagOpts.Inline() {
+				nested = name + "."
+			}
+			s.structFor(reflect.Indirect(val)).columns(nested, add, visiting)
+		} else {
+			add(name, val.Interface())
+		}
+	}
+}
+
+// Fields returns a slice of Fields. A struct tag with the content of "-"
+// ignores the checking of that particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+//
+// It panics if s's kind is not struct.
+func (s *Struct) Fields() []*Field {
+	defer s.lock()()
+
+	return getFields(s)
+}
+
+// ForEach calls fn for every field of the struct, in the same order as Fields,
+// without allocating the slice of Fields. It stops at the first non-nil error
+// returned by fn and returns it. Unlike Fields it doesn't lock the Locker of
+// s, as fn may well read s itself. A struct tag with the content of "-"
+// ignores that particular field.
+func (s *Struct) ForEach(fn func(f *Field) error) error {
+	for _, field := range s.typeFields(s.value.Type()).fields {
+		if err := fn(s.newField(field, s.value.Field(field.Index[0]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock locks the Locker of s, if any, and returns the function to unlock it.
+func (s *Struct) lock() func() {
+	if s.Locker == nil {
+		return func() {}
+	}
+	s.Locker.Lock()
+	return s.Locker.Unlock
+}
+
+// NonZeroFields returns a slice of the exported Fields which are not zero
+// values. A struct tag with the content of "-" ignores the checking of that
+// particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+func (s *Struct) NonZeroFields() []*Field {
+	return s.fieldsByZero(false)
+}
+
+// ZeroValuedFields returns a slice of the exported Fields which are zero
+// values (not initialized). A struct tag with the content of "-" ignores the
+// checking of that particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+func (s *Struct) ZeroValuedFields() []*Field {
+	return s.fieldsByZero(true)
+}
+
+// fieldsByZero returns the exported Fields for which IsZero returns zero.
+func (s *Struct) fieldsByZero(zero bool) []*Field {
+	var fields []*Field
+
+	for _, f := range getFields(s) {
+		if f.IsExported() && f.IsZero() == zero {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// SettableFields returns a slice of the Fields which can be set, as reported
+// by Field.CanSet: exported fields without the option of "readonly", of a
+// struct passed by pointer. A struct tag with the content of "-" ignores the
+// checking of that particular field. Example:
+//
+//	// Only Name can be edited in a form.
+//	ID   int    `structs:"id,readonly"`
+//	Name string `structs:"name"`
+func (s *Struct) SettableFields() []*Field {
+	var fields []*Field
+
+	for _, f := range getFields(s) {
+		if f.CanSet() {
+			fields = append(fields, f)
+		}
+	}
+
+	return fields
+}
+
+// Names returns a slice of field names. A struct tag with the content of "-"
+// ignores the checking of that particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+//
+// The names are in declaration order, but they include unexported fields and
+// fields skipped by Values, so they don't line up with Values. Use Columns for
+// names matching Values, or Keys for keys matching OrderedMap.
+//
+// It panics if s's kind is not struct.
+func (s *Struct) Names() []string {
+	fields := getFields(s)
+
+	names := make([]string, len(fields))
+
+	for i, field := range fields {
+		names[i] = field.Name()
+	}
+
+	return names
+}
+
+// Len returns the number of exported fields of the struct without the struct
+// tag "-", which are the fields Map and Values emit. It doesn't look at the
+// values, so fields skipped because of the option "omitempty" are counted, and
+// a struct field with the option "flatten" or "inline" counts as one. It's
+// meant as a capacity hint, ie:
+//
+//	m := make(map[string]interface{}, s.Len())
+func (s *Struct) Len() int {
+	return len(s.structFields())
+}
+
+// FieldNamesByKind returns the names of the exported fields grouped by their
+// kind, in declaration order. If elem is true, pointer fields are grouped by
+// the kind of the type they point to instead of reflect.Ptr. A struct tag with
+// the content of "-" ignores that particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+func (s *Struct) FieldNamesByKind(elem bool) map[reflect.Kind][]string {
+	kinds := make(map[reflect.Kind][]string)
+
+	for _, field := range s.structFields() {
+		t := field.Type
+		if elem {
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+		}
+
+		kinds[t.Kind()] = append(kinds[t.Kind()], field.Name)
+	}
+
+	return kinds
+}
+
+// NamesWithTag returns, in declaration order, the tag names under the given
+// tag key of the exported fields which have a tag value for that key. A field
+// with a tag value without a name, such as ",omitempty", is returned with its
+// field name. Fields without a tag value and with the content of "-" are
+// omitted. Example:
+//
+//	// NamesWithTag("db") returns []string{"id", "Name"}.
+//	ID      int    `db:"id"`
+//	Name    string `db:",omitempty"`
+//	Ignored string `db:"-"`
+//	Other   string
+func (s *Struct) NamesWithTag(key string) []string {
+	var names []string
+	s.tagNames(key, func(_, name string) {
+		names = append(names, name)
+	})
+	return names
+}
+
+// TagMap returns a map from the field names to the tag names under the given
+// tag key of the exported fields which have a tag value for that key. Tag
+// names are derived as in NamesWithTag. Example:
+//
+//	// TagMap("db") returns map[string]string{"ID": "id", "Name": "Name"}.
+//	ID      int    `db:"id"`
+//	Name    string `db:",omitempty"`
+//	Ignored string `db:"-"`
+//	Other   string
+func (s *Struct) TagMap(key string) map[string]string {
+	out := make(map[string]string)
+	s.tagNames(key, func(field, name string) {
+		out[field] = name
+	})
+	return out
+}
+
+// TagNameToField is the inverse of TagMap: it returns a map from the tag names
+// under the given tag key to the field names. If several fields share a tag
+// name, the last of them wins.
+func (s *Struct) TagNameToField(key string) map[string]string {
+	out := make(map[string]string)
+	s.tagNames(key, func(field, name string) {
+		out[name] = field
+	})
+	return out
+}
+
+// tagNames calls fn, in declaration order, with the field name and the tag
+// name under the given tag key of every exported field which has a tag value
+// for that key other than "-".
+func (s *Struct) tagNames(key string, fn func(field, name string)) {
+	t := s.value.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get(key)
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name, _ := parseTag(tag)
+		if name == "" {
+			name = field.Name
+		}
+
+		fn(field.Name, name)
+	}
+}
+
+func getFields(s *Struct) []*Field {
+	v := s.value
+	cached := s.typeFields(v.Type()).fields
+
+	fields := make([]*Field, len(cached))
+
+	for i, field := range cached {
+		fields[i] = &Field{
+			field:      field.StructField,
+			value:      v.Field(field.Index[0]),
+			defaultTag: s.tagKey(),
+			owner:      s,
+		}
+	}
+
+	return fields
+}
+
+// Walk calls fn for every field of the struct, depth-first in declaration
+// order, with the path of field names from the struct to the field. The fields
+// of nested structs, and of non-nil pointers to structs, are visited right
+// after the field itself. A struct tag with the content of "-" ignores that
+// particular field. Example:
+//
+//	// Field is not visited by Walk.
+//	Field Server `structs:"-"`
+//
+// If fn returns SkipField, the nested fields of the visited field are skipped.
+// Any other non-nil error stops the walk and is returned by Walk. The fields of
+// a struct which is already being walked further up, through a reference
+// cycle, are not visited again.
+func (s *Struct) Walk(fn func(path []string, f *Field) error) error {
+	return walk(s, nil, make(map[ptrKey]bool), fn)
+}
+
+// walk calls fn for the fields of the struct s and their nested fields, with
+// their names appended to path. visiting contains the structs being walked
+// from the outermost one down to s, to break reference cycles.
+func walk(s *Struct, path []string, visiting map[ptrKey]bool, fn func(path []string, f *Field) error) error {
+	leave, ok := visit(visiting, s.value)
+	if !ok {
+		return nil
+	}
+	defer leave()
+
+	for _, field := range getFields(s) {
+		fieldPath := make([]string, len(path)+1)
+		copy(fieldPath, path)
+		fieldPath[len(path)] = field.Name()
+
+		err := fn(fieldPath, field)
+		if err == SkipField {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		nested := s.indirect(field.value)
+		if nested.Kind() == reflect.Struct {
+			if err := walk(s.structFor(nested), fieldPath, visiting, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Field returns a new Field struct that provides several high level functions
+// around a single struct field entity or nil if the field was not found.
+func (s *Struct) Field(name string) *Field {
+	field, ok := s.value.Type().FieldByName(name)
+	if !ok {
+		return nil
+	}
+	return &Field{
+		field:      field,
+		value:      s.value.FieldByName(name),
+		defaultTag: s.tagKey(),
+		owner:      s,
+	}
+}
+
+// FieldValue returns the value of the field with the given name and true, or
+// nil and false if the field is not found or not exported. Unlike
+// Field(name).Value() it never panics, including for fields promoted through
+// a nil embedded pointer.
+func (s *Struct) FieldValue(name string) (interface{}, bool) {
+	field, ok := s.value.Type().FieldByName(name)
+	if !ok || field.PkgPath != "" {
+		return nil, false
+	}
+
+	v, err := s.value.FieldByIndexErr(field.Index)
+	if err != nil || !v.CanInterface() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+// FieldByIndex returns the Field for the given index sequence, as in
+// reflect.Type.FieldByIndex, dereferencing pointers as needed. This resolves
+// promoted fields of embedded structs precisely. It returns false if the index
+// is empty, if any step is out of range, or if a nil pointer or a non struct
+// value is encountered before the last step.
+func (s *Struct) FieldByIndex(index []int) (*Field, bool) {
+	if len(index) == 0 {
+		return nil, false
+	}
+
+	v := s.value
+	owner := s
+	var field reflect.StructField
+
+	for n, i := range index {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() != reflect.Struct || i < 0 || i >= v.NumField() {
+			return nil, false
+		}
+
+		if n > 0 {
+			owner = s.structFor(v)
+		}
+
+		field = v.Type().Field(i)
+		v = v.Field(i)
+	}
+
+	return &Field{
+		field:      field,
+		value:      v,
+		defaultTag: s.tagKey(),
+		owner:      owner,
+	}, true
+}
+
+// Apply sets the fields named by the keys of updates to the associated values,
+// with the same rules as Field.Set. A key is either the name of an exported
+// field or its tag name, fields with the tag content of "-" and fields promoted
+// from embedded structs don't match any key. The updates are all or nothing:
+// if a key doesn't match any field or a value can't be assigned, the fields
+// already set are restored to their previous values and the error, prefixed
+// with the key, is returned.
+func (s *Struct) Apply(updates map[string]interface{}) error {
+	if err := settable(s.value); err != nil {
+		return err
+	}
+
+	// apply the updates in a stable order, so the same key fails every time
+	keys := make([]string, 0, len(updates))
+	for key := range updates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var set []*Field
+	var prev []reflect.Value
+
+	rollback := func() {
+		for i := len(set) - 1; i >= 0; i-- {
+			set[i].value.Set(prev[i])
+		}
+	}
+
+	for _, key := range keys {
+		f := s.fieldByKey(key)
+		if f == nil {
+			rollback()
+			return fmt.Errorf("%s: no such field", key)
+		}
+
+		old := reflect.New(f.value.Type()).Elem()
+		if f.IsExported() {
+			old.Set(f.value)
+		}
+
+		if err := f.Set(updates[key]); err != nil {
+			rollback()
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		set = append(set, f)
+		prev = append(prev, old)
+	}
+
+	return nil
+}
+
+// fieldByKey returns the exported field with the given name or, failing that,
+// with the given tag name, or nil if there is none. Fields with the tag content
+// of "-" are never returned.
+func (s *Struct) fieldByKey(key string) *Field {
+	fields := s.typeFields(s.value.Type()).exported
+
+	for _, field := range fields {
+		if field.Name == key {
+			return s.newField(field, s.value.Field(field.Index[0]))
+		}
+	}
+
+	for _, field := range fields {
+		if field.tagName == key {
+			return s.newField(field, s.value.Field(field.Index[0]))
+		}
+	}
+
+	return nil
+}
+
+// Patch applies the non-nil pointer fields of src, a struct or a pointer to a
+// struct, to the fields of s with the same name, as the PATCH idiom of HTTP
+// handlers does: a nil pointer leaves the field untouched, while a non-nil
+// pointer sets it to the value pointed to, even if it's a zero value. If the
+// field of s is a pointer itself, it's set to a pointer to a copy of the value.
+// Other fields of src, and fields with the struct tag "-", are ignored.
+// Example:
+//
+//	type UserPatch struct {
+//		Name  *string
+//		Admin *bool
+//	}
+//
+// Values are assigned as Apply does, with the same rules as Field.Set: the
+// patch is all or nothing, and it returns an error if a field of src doesn't
+// match any field of s, if a value can't be assigned, or if s is not settable
+// (not created from a pointer).
+func (s *Struct) Patch(src interface{}) error {
+	v := reflect.ValueOf(src)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("can't patch %s with %T", s.value.Type(), src)
+	}
+
+	updates := make(map[string]interface{})
+
+	for _, field := range s.typeFields(v.Type()).exported {
+		val := v.Field(field.Index[0])
+		if val.Kind() != reflect.Ptr || val.IsNil() {
+			continue
+		}
+
+		elem := val.Elem()
+		if f := s.fieldByKey(field.Name); f != nil && f.Kind() == reflect.Ptr {
+			p := reflect.New(elem.Type())
+			p.Elem().Set(elem)
+			elem = p
+		}
+		updates[field.Name] = elem.Interface()
+	}
+
+	return s.Apply(updates)
+}
+
+// SetByTag sets the exported field whose tag name under the given tag key is
+// tagName to val, with the same rules as Field.Set. Example:
+//
+//	// SetByTag("json", "user_id", 42) sets the UserID field.
+//	UserID int `json:"user_id"`
+//
+// It returns an error if there is no such field or if Field.Set fails.
+func (s *Struct) SetByTag(tagKey, tagName string, val interface{}) error {
+	for _, field := range cachedFields(s.value.Type(), tagKey).exported {
+		if field.tagName != tagName {
+			continue
+		}
+
+		f := &Field{
+			field:      field.StructField,
+			value:      s.value.Field(field.Index[0]),
+			defaultTag: s.tagKey(),
+			owner:      s,
+		}
+		return f.Set(val)
+	}
+
+	return fmt.Errorf("no field with tag %s:%q", tagKey, tagName)
+}
+
+// HasField returns true if the struct has a field with the given name. Unlike
+// Field it doesn't allocate a Field.
+func (s *Struct) HasField(name string) bool {
+	_, ok := s.value.Type().FieldByName(name)
+	return ok
+}
+
+// HasTag returns true if any field of the struct has the given tag name under
+// the tag key. Example:
+//
+//	// HasTag("json", "user_id") returns true.
+//	UserID int `json:"user_id,omitempty"`
+func (s *Struct) HasTag(key, name string) bool {
+	// fields without a tag name never match
+	if name == "" {
+		return false
+	}
+
+	for _, field := range cachedFields(s.value.Type(), key).fields {
+		if field.tagName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldByPath returns the Field for the given dot separated path of field
+// names, such as "Server.TLS.CertFile", descending into nested structs and
+// dereferencing pointers as needed. It returns false if any segment of the
+// path is not found, or if a nil pointer or a non struct value is encountered
+// before the last segment, including a nil embedded pointer a segment is
+// promoted through.
+func (s *Struct) FieldByPath(path string) (*Field, bool) {
+	v := s.value
+	owner := s
+	var f *Field
+
+	for i, name := range strings.Split(path, ".") {
+		if i > 0 {
+			v = s.indirect(f.value)
+			if v.Kind() != reflect.Struct {
+				return nil, false
+			}
+			owner = s.structFor(v)
+		}
+
+		field, val, ok := fieldByName(v, name)
+		if !ok {
+			return nil, false
+		}
+
+		f = &Field{
+			field:      field,
+			value:      val,
+			defaultTag: s.tagKey(),
+			owner:      owner,
+		}
+	}
+
+	return f, true
+}
+
+// fieldByName returns the field of the struct value v with the given name and
+// its value, as reflect.Value.FieldByName does, or false if there's no such
+// field or if it's promoted through a nil embedded pointer.
+func fieldByName(v reflect.Value, name string) (reflect.StructField, reflect.Value, bool) {
+	field, ok := v.Type().FieldByName(name)
+	if !ok {
+		return reflect.StructField{}, reflect.Value{}, false
+	}
+
+	val, err := v.FieldByIndexErr(field.Index)
+	if err != nil {
+		return reflect.StructField{}, reflect.Value{}, false
+	}
+	return field, val, true
+}
+
+// IsZero returns true if all fields in a struct is a zero value (not
+// initialized) A struct tag with the content of "-" ignores the checking of
+// that particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+//
+// A value with the option of "omitnested" stops iterating further if the type
+// is a struct. Example:
+//
+//	// Field is not processed further by this package.
+//	Field time.Time     `structs:"myName,omitnested"`
+//	Field *http.Request `structs:",omitnested"`
+//
+// Note that only exported fields of a struct can be accessed, non exported
+// fields will be neglected. It panics if s's kind is not struct.
+func (s *Struct) IsZero() bool {
+	fields := s.structFields()
+
+	for _, field := range fields {
+		val := s.value.Field(field.Index[0])
+
+		tagOpts := field.tagOpts
+
+		if IsStruct(val.Interface()) && !s.isLeaf(val.Type()) && !tagOpts.Has("omitnested") {
+			ok := s.sub(val.Interface()).IsZero()
+			if !ok {
+				return false
+			}
+
+			continue
+		}
+
+		// zero value of the given field, such as "" for string, 0 for int
+		zero := reflect.Zero(val.Type()).Interface()
+
+		// current value of the given field
+		current := val.Interface()
+
+		if !reflect.DeepEqual(current, zero) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsDeepZero is like IsZero, but it looks through pointers: a non-nil pointer
+// counts as zero if the value it points to is zero, so a struct whose every
+// leaf is zero is zero regardless of pointer wrapping. Nested structs are
+// always checked field by field, and structs without exported fields, ie:
+// time.Time, are compared with their zero value as a whole. A struct tag with
+// the content of "-" ignores the checking of that particular field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+//
+// Note that only exported fields of a struct can be accessed, non exported
+// fields will be neglected.
+func (s *Struct) IsDeepZero() bool {
+	return s.isDeepZero(s.value, make(map[ptrKey]bool))
+}
+
+// isDeepZero reports whether v is deeply zero as described in IsDeepZero. The
+// pointers in visiting are being checked further up and count as zero.
+func (s *Struct) isDeepZero(v reflect.Value, visiting map[ptrKey]bool) bool {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return true
+		}
+
+		key := ptrKey{typ: v.Type(), addr: v.Pointer()}
+		if visiting[key] {
+			return true
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+
+		return s.isDeepZero(v.Elem(), visiting)
+	case reflect.Struct:
+		fields := s.typeFields(v.Type()).exported
+		if len(fields) == 0 {
+			break
+		}
+
+		for _, field := range fields {
+			if !s.isDeepZero(v.Field(field.Index[0]), visiting) {
+				return false
+			}
+		}
+		return true
+	}
+
+	zero := reflect.Zero(v.Type()).Interface()
+	return reflect.DeepEqual(v.Interface(), zero)
+}
+
+// HasZero returns true if a field in a struct is not initialized (zero value).
+// A struct tag with the content of "-" ignores the checking of that particular
+// field. Example:
+//
+//	// Field is ignored by this package.
+//	Field bool `structs:"-"`
+//
+// A value with the option of "omitnested" stops iterating further if the type
+// is a struct. Example:
+//
+//	// Field is not processed further by this package.
+//	Field time.Time     `structs:"myName,omitnested"`
+//	Field *http.Request `structs:",omitnested"`
+//
+// Note that only exported fields of a struct can be accessed, non exported
+// fields will be neglected. It panics if s's kind is not struct.
+func (s *Struct) HasZero() bool {
+	fields := s.structFields()
+
+	for _, field := range fields {
+		val := s.value.Field(field.Index[0])
+
+		tagOpts := field.tagOpts
+
+		if IsStruct(val.Interface()) && !s.isLeaf(val.Type()) && !tagOpts.Has("omitnested") {
+			ok := s.sub(val.Interface()).HasZero()
+			if ok {
+				return true
+			}
+
+			continue
+		}
+
+		// zero value of the given field, such as "" for string, 0 for int
+		zero := reflect.Zero(val.Type()).Interface()
+
+		// current value of the given field
 		current := val.Interface()
 
-		if !reflect.DeepEqual(current, zero) {
-			return false
-		}
+		if reflect.DeepEqual(current, zero) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Scan copies the exported fields of s into the struct dst points to, which
+// may be of a different type. Each field is copied into the field of dst with
+// the same key, as described in Map, if its value is assignable with the same
+// rules as Field.Set. Nested structs of different types are scanned
+// recursively. Fields which don't match on either side are skipped. A struct
+// tag with the content of "-" ignores that particular field on either side.
+//
+// It returns an error if dst is not a non-nil pointer to struct.
+func (s *Struct) Scan(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can't scan into %T, need a non-nil pointer to struct", dst)
+	}
+
+	s.scan(v.Elem())
+	return nil
+}
+
+// SetAll sets every exported field of s to the value of the exported field
+// with the same name of src, including zero values. src is a struct, or a
+// pointer to struct, which may be of a different type than s. Fields are only
+// set if the type of the field of src is assignable to the type of the field
+// of s, fields which don't match on either side are skipped. A struct tag with
+// the content of "-" ignores that particular field on either side, and fields
+// of s with the option of "readonly" are skipped as well.
+//
+// It returns an error if s is not settable (not created from a pointer) or if
+// src is not a struct.
+func (s *Struct) SetAll(src interface{}) error {
+	if err := settable(s.value); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(src)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("can't set %s from %T", s.value.Type(), src)
+	}
+
+	dstFields := make(map[string]structField)
+	for _, field := range s.structFields() {
+		dstFields[field.Name] = field
+	}
+
+	for _, field := range s.typeFields(v.Type()).exported {
+		dstField, ok := dstFields[field.Name]
+		if !ok || !field.Type.AssignableTo(dstField.Type) || dstField.tagOpts.Has("readonly") {
+			continue
+		}
+		s.value.Field(dstField.Index[0]).Set(v.Field(field.Index[0]))
+	}
+
+	return nil
+}
+
+// scan copies the exported fields of s into the matching fields of the struct
+// v.
+func (s *Struct) scan(v reflect.Value) {
+	n := *s
+	n.value = v
+
+	dstFields := make(map[string]structField)
+	for _, field := range n.structFields() {
+		dstFields[n.key(field)] = field
+	}
+
+	for _, field := range s.structFields() {
+		dstField, ok := dstFields[s.key(field)]
+		if !ok {
+			continue
+		}
+
+		src := s.value.Field(field.Index[0])
+		dst := v.Field(dstField.Index[0])
+
+		if src.Kind() == reflect.Struct && dst.Kind() == reflect.Struct && src.Type() != dst.Type() {
+			sub := *s
+			sub.raw = src.Interface()
+			sub.value = src
+			sub.scan(dst)
+			continue
+		}
+
+		if value, err := assignValue(src.Interface(), dst.Type()); err == nil {
+			dst.Set(value)
+		}
+	}
+}
+
+// ZeroFields sets the fields with the given names to their zero values, or
+// every exported field if no names are given. A struct tag with the content of
+// "-" ignores that particular field. Example:
+//
+//	// Field is never zeroed by ZeroFields.
+//	Field *sync.Pool `structs:"-"`
+//
+// Fields with the option of "readonly" are skipped if no names are given, and
+// fail like any other field that can't be set if they are named.
+//
+// It returns an error listing every field that couldn't be set, such as
+// unexported or unknown fields, or any field if s is not settable (not created
+// from a pointer).
+func (s *Struct) ZeroFields(names ...string) error {
+	var fields []*Field
+	var errs multiError
+
+	if len(names) == 0 {
+		for _, f := range getFields(s) {
+			if f.IsExported() && !f.readOnly() {
+				fields = append(fields, f)
+			}
+		}
+	} else {
+		for _, name := range names {
+			f := s.Field(name)
+			if f == nil {
+				errs = append(errs, fmt.Errorf("%s: no such field", name))
+				continue
+			}
+			if lookupTag(f.field.Tag, s.tagKey()) == "-" {
+				continue
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	for _, f := range fields {
+		if err := f.Zero(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Name(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Merge copies every non-zero exported field of src into the same field of s.
+// Nested structs are merged recursively instead of being replaced as a whole,
+// unless they are leaf types or don't have any exported fields, ie: time.Time.
+// A struct tag with the content of "-" ignores that particular field. Example:
+//
+//	// Field is never overwritten by Merge.
+//	Field string `structs:"-"`
+//
+// Fields with the option of "readonly" are not overwritten either.
+//
+// It returns an error if src is not of the same struct type as s, or if s is
+// not settable (not created from a pointer).
+func (s *Struct) Merge(src interface{}) error {
+	v := reflect.ValueOf(src)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Invalid || v.Type() != s.value.Type() {
+		return fmt.Errorf("can't merge %T into %s", src, s.value.Type())
+	}
+
+	if err := settable(s.value); err != nil {
+		return err
+	}
+
+	s.merge(v)
+	return nil
+}
+
+// merge copies the non-zero exported fields of v, which is of the same type as
+// s, into s.
+func (s *Struct) merge(v reflect.Value) {
+	for _, field := range s.structFields() {
+		if field.tagOpts.Has("readonly") {
+			continue
+		}
+
+		src := v.Field(field.Index[0])
+		dst := s.value.Field(field.Index[0])
+
+		zero := reflect.Zero(src.Type()).Interface()
+		if reflect.DeepEqual(src.Interface(), zero) {
+			continue
+		}
+
+		if src.Kind() == reflect.Struct && !s.isLeaf(src.Type()) {
+			n := s.structFor(dst)
+			if len(n.structFields()) > 0 {
+				n.merge(src)
+				continue
+			}
+		}
+
+		dst.Set(src)
+	}
+}
+
+// Diff compares every exported field of s with the same field of other using
+// reflect.DeepEqual and returns a map of the fields that differ, where the keys
+// of the map are the field names and the values of the map the values of the
+// fields in other. Nested structs are compared field by field and their
+// differing fields are keyed by the dot separated path, such as "Server.Port".
+// A struct tag with the content of "-" ignores that particular field. Example:
+//
+//	// Field is never reported by Diff.
+//	Field bool `structs:"-"`
+//
+// A tag value with the option of "omitnested" compares the nested struct as
+// a whole and reports it under its own name. Example:
+//
+//	// Field is reported as "Server" if any of its fields differ.
+//	Server Server `structs:",omitnested"`
+//
+// It returns an error if other is not of the same struct type as s.
+func (s *Struct) Diff(other interface{}) (map[string]interface{}, error) {
+	v := reflect.ValueOf(other)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Invalid || v.Type() != s.value.Type() {
+		return nil, fmt.Errorf("can't diff %s with %T", s.value.Type(), other)
+	}
+
+	out := make(map[string]interface{})
+	s.diff(v, "", out)
+	return out, nil
+}
+
+// diff adds the fields of v, which is of the same type as s, that differ from
+// the fields of s to out, prefixing their names with prefix.
+func (s *Struct) diff(v reflect.Value, prefix string, out map[string]interface{}) {
+	for _, field := range s.structFields() {
+		val := s.value.Field(field.Index[0])
+		otherVal := v.Field(field.Index[0])
+
+		tagOpts := field.tagOpts
+
+		if val.Kind() == reflect.Struct && !s.isLeaf(val.Type()) && !tagOpts.Has("omitnested") {
+			n := s.structFor(val)
+			if len(n.structFields()) > 0 {
+				n.diff(otherVal, prefix+field.Name+".", out)
+				continue
+			}
+		}
+
+		if !s.newField(field, val).Equal(s.newField(field, otherVal)) {
+			out[prefix+field.Name] = otherVal.Interface()
+		}
+	}
+}
+
+// Equal returns true if every exported field of s is equal to the same field
+// of other. Nested structs, and pointers to structs, are compared field by
+// field with the same rules, so that unexported fields, such as caches or
+// mutexes, are never compared. All other values are compared with
+// reflect.DeepEqual. A struct tag with the content of "-" ignores that
+// particular field. Example:
+//
+//	// Field is not compared by Equal.
+//	Field map[string]string `structs:"-"`
+//
+// If the NilEqualsZero field of s is true, a nil pointer is equal to a pointer
+// to a zero value. It returns false if other is not of the same struct type as
+// s.
+func (s *Struct) Equal(other interface{}) bool {
+	v := reflect.ValueOf(other)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Invalid || v.Type() != s.value.Type() {
+		return false
+	}
+
+	return s.equalStruct(s.value, v, nil, make(map[[2]ptrKey]bool))
+}
+
+// DeepEqualIgnore is the same as Equal, except that the fields with the given
+// names are not compared. A name is either the name of a field or its tag
+// name, and the fields of nested structs are ignored with a dotted path of
+// names, such as "Meta.UpdatedAt". Names that don't match any field are
+// ignored. Example:
+//
+//	// ok is true if got and want only differ in their IDs and timestamps.
+//	ok := New(got).DeepEqualIgnore(want, "ID", "Meta.CreatedAt", "updated_at")
+func (s *Struct) DeepEqualIgnore(other interface{}, ignore ...string) bool {
+	v := reflect.ValueOf(other)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Invalid || v.Type() != s.value.Type() {
+		return false
+	}
+
+	paths := make(ignoredPaths)
+	for _, name := range ignore {
+		paths.add(strings.Split(name, "."))
+	}
+
+	return s.equalStruct(s.value, v, paths, make(map[[2]ptrKey]bool))
+}
+
+// ignoredPaths is a tree of the names of the fields DeepEqualIgnore ignores. A
+// name mapped to nil ignores the whole field, otherwise the names it's mapped
+// to are ignored within the field.
+type ignoredPaths map[string]ignoredPaths
+
+// add adds the path of names to p.
+func (p ignoredPaths) add(path []string) {
+	name := path[0]
+	if len(path) == 1 {
+		p[name] = nil
+		return
+	}
+
+	sub, ok := p[name]
+	if ok && sub == nil {
+		// the whole field is ignored already
+		return
+	}
+	if !ok {
+		sub = make(ignoredPaths)
+		p[name] = sub
+	}
+	sub.add(path[1:])
+}
+
+// lookup returns the names ignored within field and true if field is ignored
+// as a whole, matching its tag name before its name.
+func (p ignoredPaths) lookup(field structField) (ignoredPaths, bool) {
+	if field.tagName != "" {
+		if sub, ok := p[field.tagName]; ok {
+			return sub, sub == nil
+		}
+	}
+	sub, ok := p[field.Name]
+	return sub, ok && sub == nil
+}
+
+// equalStruct compares the exported fields of the structs a and b, which are
+// of the same type, except the ones in ignore. visited contains the pairs of
+// pointers already compared, see equalValue.
+func (s *Struct) equalStruct(a, b reflect.Value, ignore ignoredPaths, visited map[[2]ptrKey]bool) bool {
+	fields := s.typeFields(a.Type()).exported
+
+	// structs without exported fields, ie: time.Time, are compared as a
+	// whole
+	if len(fields) == 0 {
+		return reflect.DeepEqual(a.Interface(), b.Interface())
+	}
+
+	for _, field := range fields {
+		sub, skip := ignore.lookup(field)
+		if skip {
+			continue
+		}
+
+		if !s.equalValue(a.Field(field.Index[0]), b.Field(field.Index[0]), sub, visited) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalValue compares the values a and b, which are of the same type. ignore
+// lists the fields not compared if they are structs, see ignoredPaths. A pair
+// of pointers found in visited is equal, as reflect.DeepEqual assumes, so that
+// reference cycles end.
+func (s *Struct) equalValue(a, b reflect.Value, ignore ignoredPaths, visited map[[2]ptrKey]bool) bool {
+	switch a.Kind() {
+	case reflect.Struct:
+		return s.equalStruct(a, b, ignore, visited)
+	case reflect.Ptr:
+		if a.IsNil() != b.IsNil() {
+			if !s.NilEqualsZero {
+				return false
+			}
+			if a.IsNil() {
+				a, b = b, a
+			}
+
+			// the zero value b stands for has no address
+			pair := [2]ptrKey{{typ: a.Type(), addr: a.Pointer()}, {typ: a.Type()}}
+			if visited[pair] {
+				return true
+			}
+			visited[pair] = true
+
+			return s.equalValue(a.Elem(), reflect.Zero(a.Type().Elem()), ignore, visited)
+		}
+
+		if a.Pointer() == b.Pointer() {
+			return true
+		}
+
+		if a.Elem().Kind() == reflect.Struct {
+			pair := [2]ptrKey{{typ: a.Type(), addr: a.Pointer()}, {typ: b.Type(), addr: b.Pointer()}}
+			if visited[pair] {
+				return true
+			}
+			visited[pair] = true
+
+			return s.equalStruct(a.Elem(), b.Elem(), ignore, visited)
+		}
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// Clone returns a pointer to a deep copy of the struct. Every exported field
+// is copied recursively: pointers are followed, and slices, arrays and maps are
+// copied element by element, so the copy doesn't share any memory with the
+// original. Unexported fields are left as zero values, except for nested
+// structs without any exported fields, ie: time.Time, which are copied as a
+// whole. A struct tag with the content of "-" ignores that particular field.
+// Example:
+//
+//	// Field is left as zero value in the copy.
+//	Field []byte `structs:"-"`
+//
+// The returned value can be wrapped again with New.
+func (s *Struct) Clone() interface{} {
+	c := &cloner{
+		tagName: s.tagKey(),
+		seen:    make(map[ptrKey]reflect.Value),
+	}
+
+	out := reflect.New(s.value.Type())
+	c.copyStruct(out.Elem(), s.value)
+	return out.Interface()
+}
+
+// CopyInto deep copies the exported fields of the struct into dst, which must
+// be a non-nil pointer to a struct of the same type, as Clone does, but
+// without allocating the destination, ie: to reuse structs from a pool. Every
+// copied field is overwritten, so fields which are zero in s end up zero in
+// dst as well. Unexported fields of dst, and fields with the struct tag "-",
+// are left as they are.
+func (s *Struct) CopyInto(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("can't copy into %T: not a non-nil pointer", dst)
+	}
+	if v = v.Elem(); v.Type() != s.value.Type() {
+		return fmt.Errorf("can't copy %s into %T", s.value.Type(), dst)
+	}
+
+	c := &cloner{
+		tagName: s.tagKey(),
+		seen:    make(map[ptrKey]reflect.Value),
+	}
+
+	for _, field := range s.structFields() {
+		// copy into a fresh value, copyValue expects a zero destination
+		val := reflect.New(field.Type).Elem()
+		c.copyValue(val, s.value.Field(field.Index[0]))
+		v.Field(field.Index[0]).Set(val)
+	}
+	return nil
+}
+
+// ptrKey identifies a pointer by its type and address, ie: to detect reference
+// cycles. Both are needed since a pointer to a struct and a pointer to its
+// first field have the same address.
+type ptrKey struct {
+	typ  reflect.Type
+	addr uintptr
+}
+
+// visit adds the struct v to visiting and returns a func removing it again, or
+// false if v is in visiting already, which means it's reached through a
+// reference cycle. Structs which are not addressable can't be reached through
+// a pointer, so they are not added.
+func visit(visiting map[ptrKey]bool, v reflect.Value) (leave func(), ok bool) {
+	if !v.CanAddr() {
+		return func() {}, true
+	}
+
+	p := v.Addr()
+	key := ptrKey{typ: p.Type(), addr: p.Pointer()}
+	if visiting[key] {
+		return nil, false
+	}
+
+	visiting[key] = true
+	return func() { delete(visiting, key) }, true
+}
+
+// cloner deep copies values for Clone.
+type cloner struct {
+	tagName string
+	seen    map[ptrKey]reflect.Value
+}
+
+// copyStruct copies the exported fields of the struct src into dst.
+func (c *cloner) copyStruct(dst, src reflect.Value) {
+	n := &Struct{
+		value:   src,
+		TagName: c.tagName,
 	}
 
-	return true
+	fields := n.structFields()
+	if len(fields) == 0 {
+		dst.Set(src)
+		return
+	}
+
+	for _, field := range fields {
+		c.copyValue(dst.Field(field.Index[0]), src.Field(field.Index[0]))
+	}
 }
 
-// HasZero returns true if a field in a struct is not initialized (zero value).
-// A struct tag with the content of "-" ignores the checking of that particular
-// field. Example:
-//
-//	// Field is ignored by this package.
-//	Field bool `structs:"-"`
-//
-// A value with the option of "omitnested" stops iterating further if the type
-// is a struct. Example:
-//
-//	// Field is not processed further by this package.
-//	Field time.Time     `structs:"myName,omitnested"`
-//	Field *http.Request `structs:",omitnested"`
+// copyValue deep copies src into dst, which must be settable and of the same
+// type.
+func (c *cloner) copyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		c.copyStruct(dst, src)
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+
+		key := ptrKey{typ: src.Type(), addr: src.Pointer()}
+		if p, ok := c.seen[key]; ok {
+			dst.Set(p)
+			return
+		}
+
+		p := reflect.New(src.Type().Elem())
+		c.seen[key] = p
+		c.copyValue(p.Elem(), src.Elem())
+		dst.Set(p)
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+
+		elem := reflect.New(src.Elem().Type()).Elem()
+		c.copyValue(elem, src.Elem())
+		dst.Set(elem)
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+
+		dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			c.copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			c.copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+
+		dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		for _, k := range src.MapKeys() {
+			elem := reflect.New(src.Type().Elem()).Elem()
+			c.copyValue(elem, src.MapIndex(k))
+			dst.SetMapIndex(k, elem)
+		}
+	default:
+		dst.Set(src)
+	}
+}
+
+// Hash returns a hash of the exported fields of the struct, in declaration
+// order, for cache keys or change detection. Pointers are followed, nested
+// structs are hashed field by field with the same rules, and the entries of
+// maps are hashed in an order independent of the iteration order, so structs
+// with equal content have the same hash regardless of pointer identity.
+// Unexported fields and fields with a struct tag with the content of "-" are
+// ignored, except for nested structs without any exported fields, ie:
+// time.Time, which are hashed as a whole. Two time.Time values are hashed the
+// same if they're the same instant.
 //
-// Note that only exported fields of a struct can be accessed, non exported
-// fields will be neglected. It panics if s's kind is not struct.
-func (s *Struct) HasZero() bool {
-	fields := s.structFields()
+// It returns an error if a field holds a func, a chan or an unsafe.Pointer, or
+// if pointers form a cycle.
+func (s *Struct) Hash() (uint64, error) {
+	h := &hasher{
+		tagName: s.tagKey(),
+		hash:    fnv.New64a(),
+		seen:    make(map[ptrKey]bool),
+	}
 
-	for _, field := range fields {
-		val := s.value.FieldByName(field.Name)
+	if err := h.hashStruct(s.value); err != nil {
+		return 0, err
+	}
+	return h.hash.Sum64(), nil
+}
 
-		_, tagOpts := parseTag(field.Tag.Get(s.TagName))
+// hasher hashes values for Hash.
+type hasher struct {
+	tagName string
+	hash    hash.Hash64
+	buf     [8]byte
 
-		if IsStruct(val.Interface()) && !tagOpts.Has("omitnested") {
-			ok := HasZero(val.Interface())
-			if ok {
-				return true
+	// seen contains the pointers being hashed, to detect cycles
+	seen map[ptrKey]bool
+}
+
+// write writes the bytes of u to the hash.
+func (h *hasher) write(u uint64) {
+	binary.LittleEndian.PutUint64(h.buf[:], u)
+	h.hash.Write(h.buf[:])
+}
+
+// hashStruct hashes the exported fields of the struct v, or all of its fields
+// if it has no exported fields.
+func (h *hasher) hashStruct(v reflect.Value) error {
+	if v.Type() == timeType && v.CanInterface() {
+		t := v.Interface().(time.Time)
+		h.write(uint64(t.Unix()))
+		h.write(uint64(t.Nanosecond()))
+		return nil
+	}
+
+	fields := cachedFields(v.Type(), h.tagName).exported
+	if len(fields) == 0 {
+		for i := 0; i < v.NumField(); i++ {
+			if err := h.hashValue(v.Field(i)); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
 
-			continue
+	for _, field := range fields {
+		if err := h.hashValue(v.Field(field.Index[0])); err != nil {
+			return fmt.Errorf("%s: %w", field.Name, err)
 		}
+	}
+	return nil
+}
 
-		// zero value of the given field, such as "" for string, 0 for int
-		zero := reflect.Zero(val.Type()).Interface()
+// hashValue hashes v, prefixed with its kind so that values of different kinds
+// with the same bytes hash differently.
+func (h *hasher) hashValue(v reflect.Value) error {
+	h.write(uint64(v.Kind()))
 
-		// current value of the given field
-		current := val.Interface()
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			h.write(1)
+		} else {
+			h.write(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		h.write(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		h.write(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		h.write(math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		h.write(math.Float64bits(real(v.Complex())))
+		h.write(math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		h.write(uint64(v.Len()))
+		h.hash.Write([]byte(v.String()))
+	case reflect.Struct:
+		return h.hashStruct(v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			h.write(0)
+			return nil
+		}
+		h.write(1)
 
-		if reflect.DeepEqual(current, zero) {
-			return true
+		key := ptrKey{typ: v.Type(), addr: v.Pointer()}
+		if h.seen[key] {
+			return fmt.Errorf("can't hash a reference cycle through %s", v.Type())
 		}
+		h.seen[key] = true
+		defer delete(h.seen, key)
+
+		return h.hashValue(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			h.write(0)
+			return nil
+		}
+		h.write(1)
+
+		typ := v.Elem().Type().String()
+		h.write(uint64(len(typ)))
+		h.hash.Write([]byte(typ))
+		return h.hashValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		h.write(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := h.hashValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// hash every entry on its own and combine them in sorted order, the
+		// iteration order of maps is random
+		entries := make([]uint64, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			e := &hasher{tagName: h.tagName, hash: fnv.New64a(), seen: h.seen}
+			if err := e.hashValue(k); err != nil {
+				return err
+			}
+			if err := e.hashValue(v.MapIndex(k)); err != nil {
+				return err
+			}
+			entries = append(entries, e.hash.Sum64())
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+
+		h.write(uint64(len(entries)))
+		for _, e := range entries {
+			h.write(e)
+		}
+	default:
+		return fmt.Errorf("can't hash %s", v.Type())
 	}
 
-	return false
+	return nil
 }
 
 // Name returns the structs's type name within its package. For more info refer
@@ -386,27 +3392,8 @@ func (s *Struct) Name() string {
 // structFields returns the exported struct fields for a given s struct. This
 // is a convenient helper method to avoid duplicate code in some of the
 // functions.
-func (s *Struct) structFields() []reflect.StructField {
-	t := s.value.Type()
-
-	var f []reflect.StructField
-
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		// we can't access the value of unexported fields
-		if field.PkgPath != "" {
-			continue
-		}
-
-		// don't check if it's omitted
-		if tag := field.Tag.Get(s.TagName); tag == "-" {
-			continue
-		}
-
-		f = append(f, field)
-	}
-
-	return f
+func (s *Struct) structFields() []structField {
+	return s.typeFields(s.value.Type()).exported
 }
 
 func structVal(s interface{}) reflect.Value {
@@ -430,24 +3417,94 @@ func Map(s interface{}) map[string]interface{} {
 	return New(s).Map()
 }
 
+// MapWithTag is the same as Map, except that it reads the field tags under
+// the given tag key instead of DefaultTagName. It panics if s's kind is not
+// struct.
+func MapWithTag(s interface{}, tag string) map[string]interface{} {
+	n := New(s)
+	n.TagName = tag
+	return n.Map()
+}
+
 // FillMap is the same as Map. Instead of returning the output, it fills the
 // given map.
 func FillMap(s interface{}, out map[string]interface{}) {
 	New(s).FillMap(out)
 }
 
+// FillMapWithTag is the same as FillMap, except that it reads the field tags
+// under the given tag key instead of DefaultTagName.
+func FillMapWithTag(s interface{}, out map[string]interface{}, tag string) {
+	n := New(s)
+	n.TagName = tag
+	n.FillMap(out)
+}
+
+// ToJSON returns the JSON encoding of the given struct. For more info refer to
+// Struct types ToJSON() method. It panics if s's kind is not struct.
+func ToJSON(s interface{}) ([]byte, error) {
+	return New(s).ToJSON()
+}
+
+// ToJSONWithTag is the same as ToJSON, except that it reads the field tags
+// under the given tag key instead of DefaultTagName. It panics if s's kind is
+// not struct.
+func ToJSONWithTag(s interface{}, tag string) ([]byte, error) {
+	n := New(s)
+	n.TagName = tag
+	return n.ToJSON()
+}
+
 // Values converts the given struct to a []interface{}. For more info refer to
 // Struct types Values() method. It panics if s's kind is not struct.
 func Values(s interface{}) []interface{} {
 	return New(s).Values()
 }
 
+// ValuesWithTag is the same as Values, except that it reads the field tags
+// under the given tag key instead of DefaultTagName. It panics if s's kind is
+// not struct.
+func ValuesWithTag(s interface{}, tag string) []interface{} {
+	n := New(s)
+	n.TagName = tag
+	return n.Values()
+}
+
 // Fields returns a slice of *Field. For more info refer to Struct types
 // Fields() method. It panics if s's kind is not struct.
 func Fields(s interface{}) []*Field {
 	return New(s).Fields()
 }
 
+// FieldsOf is the same as Fields, except that it takes the reflect.Value of a
+// struct, or of a pointer to struct, and the tag key to read the field tags
+// under, for callers which already hold a reflect.Value. It returns an error
+// instead of panicking if v is not a struct or a non-nil pointer to one.
+func FieldsOf(v reflect.Value, tagName string) ([]*Field, error) {
+	if !v.IsValid() {
+		return nil, errors.New("invalid value, need a struct")
+	}
+
+	t := v.Type()
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, fmt.Errorf("nil %s, need a struct", t)
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", t)
+	}
+
+	s := &Struct{value: v, TagName: tagName}
+	if v.CanInterface() {
+		s.raw = v.Interface()
+	}
+
+	return getFields(s), nil
+}
+
 // Names returns a slice of field names. For more info refer to Struct types
 // Names() method. It panics if s's kind is not struct.
 func Names(s interface{}) []string {
@@ -460,12 +3517,48 @@ func IsZero(s interface{}) bool {
 	return New(s).IsZero()
 }
 
+// IsDeepZero returns true if all fields are deeply equal to a zero value. For
+// more info refer to Struct types IsDeepZero() method. It panics if s's kind is
+// not struct.
+func IsDeepZero(s interface{}) bool {
+	return New(s).IsDeepZero()
+}
+
 // HasZero returns true if any field is equal to a zero value. For more info
 // refer to Struct types HasZero() method. It panics if s's kind is not struct.
 func HasZero(s interface{}) bool {
 	return New(s).HasZero()
 }
 
+// Get returns the value of the field with the given name as a T. It returns
+// the zero value of T and false if there is no such field, if the field is not
+// exported or is promoted through a nil embedded pointer, or if its value is
+// not a T.
+func Get[T any](s *Struct, name string) (T, bool) {
+	var zero T
+
+	v, ok := s.FieldValue(name)
+	if !ok {
+		return zero, false
+	}
+
+	t, ok := v.(T)
+	if !ok {
+		return zero, false
+	}
+
+	return t, true
+}
+
+// MustGet is like Get, but it panics instead of returning false.
+func MustGet[T any](s *Struct, name string) T {
+	t, ok := Get[T](s, name)
+	if !ok {
+		panic(fmt.Sprintf("field %s is not a %s", name, reflect.TypeOf(&t).Elem()))
+	}
+	return t
+}
+
 // IsStruct returns true if the given variable is a struct or a pointer to
 // struct.
 func IsStruct(s interface{}) bool {
@@ -488,24 +3581,307 @@ func Name(s interface{}) string {
 	return New(s).Name()
 }
 
+// tagKey returns the tag keys read by s: TagName, or the TagNames separated by
+// spaces, which can't be part of a tag key, see lookupTag.
+func (s *Struct) tagKey() string {
+	if len(s.TagNames) > 0 {
+		return strings.Join(s.TagNames, " ")
+	}
+	return s.TagName
+}
+
+// optionSep returns the separator of the tag options of s.
+func (s *Struct) optionSep() string {
+	if s.TagOptionSep == "" {
+		return defaultOptionSep
+	}
+	return s.TagOptionSep
+}
+
+// key returns the key of the given field in Map.
+func (s *Struct) key(field structField) string {
+	if s.NameFunc != nil {
+		return s.NameFunc(field.Name, field.tagName)
+	}
+	if field.tagName != "" {
+		return field.tagName
+	}
+	return field.Name
+}
+
+// isEmpty returns true if the value val of field is empty for the option of
+// "omitempty", as decided by the IsEmptyFunc of s or, if it's nil, by
+// isZeroValue.
+func (s *Struct) isEmpty(field structField, val reflect.Value) bool {
+	if s.IsEmptyFunc != nil {
+		return s.IsEmptyFunc(s.newField(field, val), val.Interface())
+	}
+	return isZeroValue(val)
+}
+
+// copyContainers returns a copy of v if it's a slice or a map, copying the
+// slices and maps among its elements recursively. Other values, and nil slices
+// and maps, are returned as they are.
+func copyContainers(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(copyContainers(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), copyContainers(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
+
+// zeroer is implemented by types with their own notion of a zero value, such
+// as time.Time.
+type zeroer interface {
+	IsZero() bool
+}
+
+// isZeroValue reports whether v is a zero value. If v implements zeroer,
+// directly or through its address, its IsZero method decides; otherwise v is
+// compared against the zero value of its type with reflect.DeepEqual.
+func isZeroValue(v reflect.Value) bool {
+	if v.CanInterface() && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		if z, ok := v.Interface().(zeroer); ok {
+			return z.IsZero()
+		}
+		if v.CanAddr() {
+			if z, ok := v.Addr().Interface().(zeroer); ok {
+				return z.IsZero()
+			}
+		}
+	}
+
+	zero := reflect.Zero(v.Type()).Interface()
+	return reflect.DeepEqual(v.Interface(), zero)
+}
+
+// stringValue returns the value of a field with the "string" option. It
+// returns false if val is neither a fmt.Stringer nor of a boolean, numeric or
+// string kind.
+func stringValue(val reflect.Value) (string, bool) {
+	if s, ok := val.Interface().(fmt.Stringer); ok {
+		return s.String(), true
+	}
+
+	switch val.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(val.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(val.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), 'g', -1, val.Type().Bits()), true
+	case reflect.String:
+		return val.String(), true
+	}
+
+	return "", false
+}
+
+// mapKey returns the key of the nested map converted from a map with the key
+// k, such as "1" for the int key 1.
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// leafTypes holds the types registered with RegisterLeafType.
+var leafTypes struct {
+	sync.RWMutex
+	types map[reflect.Type]bool
+}
+
+// RegisterLeafType registers the struct type t, or the type t points to, as a
+// leaf type for every Struct, in addition to their own LeafTypes, ie: for
+// types such as uuid.UUID or decimal.Decimal which should be treated as single
+// values everywhere. It is safe for concurrent use.
+func RegisterLeafType(t reflect.Type) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	leafTypes.Lock()
+	defer leafTypes.Unlock()
+
+	if leafTypes.types == nil {
+		leafTypes.types = make(map[reflect.Type]bool)
+	}
+	leafTypes.types[t] = true
+}
+
+// RegisterLeaf registers the type T as a leaf type, see RegisterLeafType.
+// Example:
+//
+//	structs.RegisterLeaf[uuid.UUID]()
+func RegisterLeaf[T any]() {
+	RegisterLeafType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// isRegisteredLeaf returns true if t was registered with RegisterLeafType.
+func isRegisteredLeaf(t reflect.Type) bool {
+	leafTypes.RLock()
+	defer leafTypes.RUnlock()
+	return leafTypes.types[t]
+}
+
+// isLeaf returns true if t, or the type t points to, is time.Time, one of the
+// LeafTypes of s or a type registered with RegisterLeafType.
+func (s *Struct) isLeaf(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType || isRegisteredLeaf(t) {
+		return true
+	}
+
+	for _, leaf := range s.LeafTypes {
+		if t == leaf {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sub returns a new *Struct for the nested struct v with the same options as s.
+func (s *Struct) sub(v interface{}) *Struct {
+	n := *s
+	n.raw = v
+	n.value = structVal(v)
+	n.filter = nil
+	n.renamed = nil
+	n.Locker = nil
+	return &n
+}
+
+// indirect returns the value v points to, following any number of non-nil
+// pointers, and non-nil interfaces if FollowInterfaces is set.
+func (s *Struct) indirect(v reflect.Value) reflect.Value {
+	for {
+		switch {
+		case v.Kind() == reflect.Ptr && !v.IsNil():
+			v = v.Elem()
+		case v.Kind() == reflect.Interface && s.FollowInterfaces && !v.IsNil():
+			v = v.Elem()
+		default:
+			return v
+		}
+	}
+}
+
+// structFor returns a new Struct for the struct value v with the same options
+// as s, such as the tag name, used for the fields of nested structs.
+func (s *Struct) structFor(v reflect.Value) *Struct {
+	n := *s
+	n.raw = nil
+	if v.CanInterface() {
+		n.raw = v.Interface()
+	}
+	n.value = v
+	n.filter = nil
+	n.renamed = nil
+	n.Locker = nil
+	return &n
+}
+
+// newField returns a new Field for the given field of s and its value.
+func (s *Struct) newField(field structField, val reflect.Value) *Field {
+	return &Field{
+		field:      field.StructField,
+		value:      val,
+		defaultTag: s.tagKey(),
+		owner:      s,
+	}
+}
+
 // nested retrieves recursively all types for the given value and returns the
-// nested value.
-func (s *Struct) nested(val reflect.Value) interface{} {
+// nested value. If ordered is true nested structs are returned as a []KeyValue.
+func (s *Struct) nested(val reflect.Value, ordered bool) interface{} {
 	var finalVal interface{}
 
 	v := reflect.ValueOf(val.Interface())
+
+	var ptr *ptrKey
 	if v.Kind() == reflect.Ptr {
+		if !v.IsNil() {
+			ptr = &ptrKey{typ: v.Type(), addr: v.Pointer()}
+		}
 		v = v.Elem()
 	}
 
 	switch v.Kind() {
 	case reflect.Struct:
-		n := New(val.Interface())
-		n.TagName = s.TagName
-		m := n.Map()
+		if s.isLeaf(v.Type()) {
+			finalVal = val.Interface()
+			break
+		}
+
+		// store the struct as it is beyond the maximum depth
+		if s.MaxDepth > 0 && s.depth+1 >= s.MaxDepth {
+			finalVal = val.Interface()
+			break
+		}
+
+		n := s.sub(val.Interface())
+		n.depth = s.depth + 1
+
+		if n.visiting == nil {
+			n.visiting = make(map[ptrKey]bool)
+			if r := reflect.ValueOf(s.raw); r.Kind() == reflect.Ptr {
+				n.visiting[ptrKey{typ: r.Type(), addr: r.Pointer()}] = true
+			}
+		}
+
+		// do not convert a struct again which is already being converted
+		// further up, store the pointer as it is instead
+		if ptr != nil {
+			if n.visiting[*ptr] {
+				finalVal = val.Interface()
+				break
+			}
+			n.visiting[*ptr] = true
+			defer delete(n.visiting, *ptr)
+		}
 
 		// do not add the converted value if there are no exported fields, ie:
 		// time.Time
+		if ordered {
+			kv := n.OrderedMap()
+			if len(kv) == 0 {
+				finalVal = val.Interface()
+			} else {
+				finalVal = kv
+			}
+			break
+		}
+
+		m := n.Map()
 		if len(m) == 0 {
 			finalVal = val.Interface()
 		} else {
@@ -524,13 +3900,16 @@ func (s *Struct) nested(val reflect.Value) interface{} {
 		}
 
 		// only iterate over struct types, ie: map[string]StructType,
-		// map[string][]StructType,
+		// map[string][]StructType, map[string][]*StructType and arrays such as
+		// map[string][2]StructType
 		if mapElem.Kind() == reflect.Struct ||
-			(mapElem.Kind() == reflect.Slice &&
-				mapElem.Elem().Kind() == reflect.Struct) {
+			((mapElem.Kind() == reflect.Slice || mapElem.Kind() == reflect.Array) &&
+				(mapElem.Elem().Kind() == reflect.Struct ||
+					(mapElem.Elem().Kind() == reflect.Ptr &&
+						mapElem.Elem().Elem().Kind() == reflect.Struct))) {
 			m := make(map[string]interface{}, val.Len())
 			for _, k := range val.MapKeys() {
-				m[k.String()] = s.nested(val.MapIndex(k))
+				m[mapKey(k)] = s.nested(val.MapIndex(k), ordered)
 			}
 			finalVal = m
 			break
@@ -557,7 +3936,7 @@ func (s *Struct) nested(val reflect.Value) interface{} {
 
 		slices := make([]interface{}, val.Len())
 		for x := 0; x < val.Len(); x++ {
-			slices[x] = s.nested(val.Index(x))
+			slices[x] = s.nested(val.Index(x), ordered)
 		}
 		finalVal = slices
 	default:
@@ -1,8 +1,13 @@
 package structs
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -21,6 +26,31 @@ func TestMapNonStruct(t *testing.T) {
 	_ = Map(foo)
 }
 
+func TestNewOk(t *testing.T) {
+	type T struct {
+		A string
+	}
+
+	var nilT *T
+	for _, v := range []interface{}{nil, 1, "s", []T{}, nilT, map[string]int{}} {
+		if s, ok := NewOk(v); ok || s != nil {
+			t.Errorf("NewOk(%#v): got %v, %v, want nil, false", v, s, ok)
+		}
+	}
+
+	v := &T{A: "a"}
+	for _, in := range []interface{}{*v, v, &v} {
+		s, ok := NewOk(in)
+		if !ok {
+			t.Errorf("NewOk(%T) should return true", in)
+			continue
+		}
+		if m := s.Map(); m["A"] != "a" {
+			t.Errorf("NewOk(%T).Map(): got %v", in, m)
+		}
+	}
+}
+
 func TestStructIndexes(t *testing.T) {
 	type C struct {
 		something int
@@ -171,6 +201,25 @@ func TestMap_CustomTag(t *testing.T) {
 
 }
 
+func TestWithTagName(t *testing.T) {
+	var T = struct {
+		A string `json:"x"`
+	}{"a-value"}
+
+	if m := New(T).WithTagName("json").Map(); !reflect.DeepEqual(m, map[string]interface{}{"x": "a-value"}) {
+		t.Errorf("Map with WithTagName should use the json tag, got: %v", m)
+	}
+
+	defer func() {
+		err := recover()
+		if err == nil {
+			t.Error("WithTagName with an empty tag name should panic")
+		}
+	}()
+
+	_ = New(T).WithTagName("")
+}
+
 func TestMap_MultipleCustomTag(t *testing.T) {
 	var A = struct {
 		X string `aa:"ax"`
@@ -196,6 +245,47 @@ func TestMap_MultipleCustomTag(t *testing.T) {
 	}
 }
 
+func TestMapWithTag(t *testing.T) {
+	type A struct {
+		X string `json:"x"`
+		Y int    `json:"y,omitempty"`
+		Z bool   `json:"-"`
+		N struct {
+			E string `json:"e"`
+		} `json:"n"`
+		T time.Time `json:"t,omitnested"`
+	}
+
+	a := A{X: "a-value", Z: true}
+	a.N.E = "e-value"
+
+	m := MapWithTag(a, "json")
+
+	if len(m) != 3 {
+		t.Errorf("MapWithTag should return a map of len 3, got: %d", len(m))
+	}
+
+	if m["x"] != "a-value" {
+		t.Errorf("MapWithTag should have the key x with value a-value, got: %v", m["x"])
+	}
+
+	if _, ok := m["y"]; ok {
+		t.Error("MapWithTag should omit empty field tagged as omitempty")
+	}
+
+	if _, ok := m["Z"]; ok {
+		t.Error("MapWithTag should ignore field tagged as -")
+	}
+
+	if !reflect.DeepEqual(m["n"], map[string]interface{}{"e": "e-value"}) {
+		t.Errorf("MapWithTag should read nested tags under json, got: %v", m["n"])
+	}
+
+	if _, ok := m["t"].(time.Time); !ok {
+		t.Errorf("MapWithTag should not process further field tagged as omitnested, got: %T", m["t"])
+	}
+}
+
 func TestMap_OmitEmpty(t *testing.T) {
 	type A struct {
 		Name  string
@@ -217,6 +307,146 @@ func TestMap_OmitEmpty(t *testing.T) {
 	}
 }
 
+func TestMap_OmitEmptyIsZero(t *testing.T) {
+	type A struct {
+		Time    time.Time  `structs:",omitempty"`
+		TimePtr *time.Time `structs:",omitempty"`
+		Amount  amount     `structs:",omitempty"`
+	}
+
+	loc := time.FixedZone("UTC+1", 60*60)
+	a := A{Time: time.Time{}.In(loc), Amount: amount{cents: 0, currency: "EUR"}}
+
+	m := Map(a)
+	for _, key := range []string{"Time", "TimePtr", "Amount"} {
+		if _, ok := m[key]; ok {
+			t.Errorf("Map should not contain the %s field which reports IsZero", key)
+		}
+	}
+
+	a.Amount.cents = 100
+	if _, ok := Map(a)["Amount"]; !ok {
+		t.Error("Map should contain the Amount field which is not zero")
+	}
+}
+
+// amount is zero when cents is zero, whatever the currency.
+type amount struct {
+	cents    int
+	currency string
+}
+
+func (a amount) IsZero() bool { return a.cents == 0 }
+
+func TestMap_CopySlices(t *testing.T) {
+	type Inner struct {
+		Tags []string
+	}
+	type A struct {
+		Names  []string
+		Scores map[string][]int
+		Inner  Inner
+		Nil    []string
+	}
+
+	a := &A{
+		Names:  []string{"a", "b"},
+		Scores: map[string][]int{"x": {1, 2}},
+		Inner:  Inner{Tags: []string{"t"}},
+	}
+
+	s := New(a)
+	s.CopySlices = true
+	m := s.Map()
+
+	a.Names[0] = "changed"
+	a.Scores["x"][0] = 100
+	a.Scores["y"] = []int{3}
+	a.Inner.Tags[0] = "changed"
+
+	if names := m["Names"].([]string); names[0] != "a" {
+		t.Errorf("Names should be a copy: got %v", names)
+	}
+	if scores := m["Scores"].(map[string][]int); scores["x"][0] != 1 || len(scores) != 1 {
+		t.Errorf("Scores should be a deep copy: got %v", scores)
+	}
+	if tags := m["Inner"].(map[string]interface{})["Tags"].([]string); tags[0] != "t" {
+		t.Errorf("Inner.Tags should be a copy: got %v", tags)
+	}
+	if names := m["Nil"].([]string); names != nil {
+		t.Errorf("Nil should stay nil: got %#v", names)
+	}
+
+	// without CopySlices the slices are shared
+	if names := Map(a)["Names"].([]string); &names[0] != &a.Names[0] {
+		t.Error("Names should share its backing array without CopySlices")
+	}
+}
+
+func TestMap_IsEmptyFunc(t *testing.T) {
+	type A struct {
+		Name  string   `structs:"name,omitempty"`
+		Tags  []string `structs:"tags,omitempty"`
+		Count int      `structs:"count,omitempty"`
+		Note  string   `structs:"note"`
+	}
+
+	a := A{Name: "  ", Tags: []string{}, Note: " "}
+
+	if m := Map(a); len(m) != 3 {
+		t.Errorf("Map without IsEmptyFunc should only leave out zero values, got: %v", m)
+	}
+
+	var called []string
+	s := New(a)
+	s.IsEmptyFunc = func(f *Field, v interface{}) bool {
+		called = append(called, f.Name())
+		switch v := v.(type) {
+		case string:
+			return strings.TrimSpace(v) == ""
+		case []string:
+			return len(v) == 0
+		}
+		return f.IsZero()
+	}
+
+	want := map[string]interface{}{"note": " "}
+	if m := s.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with IsEmptyFunc is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	if want := []string{"Name", "Tags", "Count"}; !reflect.DeepEqual(called, want) {
+		t.Errorf("IsEmptyFunc should be called for the omitempty fields %v, got: %v", want, called)
+	}
+}
+
+func TestMap_Durations(t *testing.T) {
+	type Config struct {
+		Timeout  time.Duration `structs:"timeout"`
+		Interval time.Duration `structs:"interval,omitempty"`
+		Retries  int           `structs:"retries"`
+	}
+
+	c := Config{Timeout: 90 * time.Minute, Retries: 3}
+
+	want := map[string]interface{}{"timeout": "1h30m0s", "retries": 3}
+	m := Map(c)
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with durations is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	var out Config
+	if err := New(&out).FillStruct(m); err != nil || out != c {
+		t.Errorf("FillStruct of the durations map: got %+v, %v, want %+v", out, err, c)
+	}
+
+	s := New(c)
+	s.RawDurations = true
+	if v := s.Map()["timeout"]; v != 90*time.Minute {
+		t.Errorf("Map with RawDurations: got %#v, want %#v", v, 90*time.Minute)
+	}
+}
+
 func TestMap_OmitNested(t *testing.T) {
 	type A struct {
 		Name  string
@@ -248,6 +478,56 @@ func TestMap_OmitNested(t *testing.T) {
 	}
 }
 
+func TestMap_OmitNestedOption(t *testing.T) {
+	type Inner struct {
+		Name string
+	}
+
+	type Middle struct {
+		Inner Inner
+		Deep  Inner `structs:",recurse"`
+	}
+
+	type Outer struct {
+		Plain   Inner
+		Ptr     *Inner
+		Recurse Middle `structs:"recurse,recurse"`
+		Flat    Inner  `structs:",flatten"`
+		Count   int
+	}
+
+	o := Outer{
+		Plain:   Inner{Name: "plain"},
+		Ptr:     &Inner{Name: "ptr"},
+		Recurse: Middle{Inner: Inner{Name: "inner"}, Deep: Inner{Name: "deep"}},
+		Flat:    Inner{Name: "flat"},
+		Count:   1,
+	}
+
+	s := New(o)
+	s.OmitNested = true
+
+	want := map[string]interface{}{
+		"Plain": Inner{Name: "plain"},
+		"Ptr":   &Inner{Name: "ptr"},
+		"recurse": map[string]interface{}{
+			"Inner": Inner{Name: "inner"},
+			"Deep":  map[string]interface{}{"Name": "deep"},
+		},
+		"Name":  "flat",
+		"Count": 1,
+	}
+
+	if m := s.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with OmitNested is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	// the default stays unchanged
+	if m := Map(o); !reflect.DeepEqual(m["Plain"], map[string]interface{}{"Name": "plain"}) {
+		t.Errorf("Map without OmitNested should process nested structs, got: %v", m["Plain"])
+	}
+}
+
 func TestMap_Nested(t *testing.T) {
 	type A struct {
 		Name string
@@ -458,6 +738,77 @@ func TestMap_NestedMapWithSliceStructValues(t *testing.T) {
 	}
 }
 
+func TestMap_NestedMapWithSlicePointerStructValues(t *testing.T) {
+	type address struct {
+		Country string `structs:"country"`
+	}
+
+	type A struct {
+		ByName map[string][]*address
+		ByID   map[int]address
+		Array  [1]address
+	}
+
+	a := A{
+		ByName: map[string][]*address{"home": {{Country: "Turkey"}}},
+		ByID:   map[int]address{1: {Country: "Germany"}},
+		Array:  [1]address{{Country: "France"}},
+	}
+
+	want := map[string]interface{}{
+		"ByName": map[string]interface{}{
+			"home": []interface{}{map[string]interface{}{"country": "Turkey"}},
+		},
+		"ByID": map[string]interface{}{
+			"1": map[string]interface{}{"country": "Germany"},
+		},
+		"Array": []interface{}{map[string]interface{}{"country": "France"}},
+	}
+
+	if m := Map(a); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map result is wrong:\n got: %v\nwant: %v", m, want)
+	}
+}
+
+func TestMap_NestedArrayWithStructValues(t *testing.T) {
+	type Address struct {
+		City string `structs:"city"`
+	}
+
+	type A struct {
+		Addresses [2]Address            `structs:"addresses"`
+		Pointers  [2]*Address           `structs:"pointers"`
+		ByName    map[string][2]Address `structs:"by_name"`
+	}
+
+	a := A{
+		Addresses: [2]Address{{City: "Bern"}, {City: "Basel"}},
+		Pointers:  [2]*Address{{City: "Zug"}, nil},
+		ByName:    map[string][2]Address{"home": {{City: "Chur"}, {}}},
+	}
+
+	want := map[string]interface{}{
+		"addresses": []interface{}{
+			map[string]interface{}{"city": "Bern"},
+			map[string]interface{}{"city": "Basel"},
+		},
+		"pointers": []interface{}{
+			map[string]interface{}{"city": "Zug"},
+			(*Address)(nil),
+		},
+		"by_name": map[string]interface{}{
+			"home": []interface{}{
+				map[string]interface{}{"city": "Chur"},
+				map[string]interface{}{"city": ""},
+			},
+		},
+	}
+
+	if m := Map(a); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map result is wrong:\n got: %v\nwant: %v", m, want)
+	}
+}
+
 func TestMap_NestedSliceWithStructValues(t *testing.T) {
 	type address struct {
 		Country string `structs:"customCountryName"`
@@ -620,124 +971,1884 @@ func TestMap_FlatnestedOverwrite(t *testing.T) {
 	}
 }
 
-func TestMap_TimeField(t *testing.T) {
+func TestMap_FlatnestedNamed(t *testing.T) {
 	type A struct {
-		CreatedAt time.Time
+		Name  string
+		Value int
 	}
 
-	a := &A{CreatedAt: time.Now().UTC()}
-	m := Map(a)
-
-	_, ok := m["CreatedAt"].(time.Time)
-	if !ok {
-		t.Error("Time field must be final")
+	type C struct {
+		Value int
+		Extra string
 	}
-}
 
-func TestFillMap(t *testing.T) {
-	var T = struct {
-		A string
-		B int
-		C bool
-	}{
-		A: "a-value",
-		B: 2,
-		C: true,
+	type B struct {
+		Name  string `structs:"name"`
+		Inner A      `structs:"inner,flatten"`
+		Other C      `structs:",flatten"`
+		Value int    `structs:"Value"`
 	}
 
-	a := make(map[string]interface{}, 0)
-	FillMap(T, a)
-
-	// we have three fields
-	if len(a) != 3 {
-		t.Errorf("FillMap should fill a map of len 3, got: %d", len(a))
+	b := B{
+		Name:  "b",
+		Inner: A{Name: "a", Value: 1},
+		Other: C{Value: 2, Extra: "c"},
+		Value: 3,
 	}
 
-	inMap := func(val interface{}) bool {
-		for _, v := range a {
-			if reflect.DeepEqual(v, val) {
-				return true
-			}
-		}
+	expectedMap := map[string]interface{}{
+		"name":  "b",
+		"Name":  "a",
+		"Value": 3,
+		"Extra": "c",
+	}
+	if m := Map(b); !reflect.DeepEqual(m, expectedMap) {
+		t.Errorf("The exprected map %+v does't correspond to %+v", expectedMap, m)
+	}
 
-		return false
+	type D struct {
+		Inner A `structs:"inner,flatten"`
+		Name  string
 	}
 
-	for _, val := range []interface{}{"a-value", 2, true} {
-		if !inMap(val) {
-			t.Errorf("FillMap should have the value %v", val)
-		}
+	d := D{Inner: A{Name: "a", Value: 1}, Name: "d"}
+
+	expectedMap = map[string]interface{}{"Name": "d", "Value": 1}
+	if m := Map(d); !reflect.DeepEqual(m, expectedMap) {
+		t.Errorf("The exprected map %+v does't correspond to %+v", expectedMap, m)
 	}
-}
 
-func TestFillMap_Nil(t *testing.T) {
-	var T = struct {
-		A string
-		B int
-		C bool
-	}{
-		A: "a-value",
-		B: 2,
-		C: true,
+	type E struct {
+		Name  string
+		Inner A `structs:"inner,flatten"`
 	}
 
-	defer func() {
-		err := recover()
-		if err != nil {
-			t.Error("FillMap should not panic if a nil map is passed")
-		}
-	}()
+	e := E{Name: "e", Inner: A{Name: "a", Value: 1}}
 
-	// nil should no
-	FillMap(T, nil)
+	expectedMap = map[string]interface{}{"Name": "e", "Value": 1}
+	if m := Map(e); !reflect.DeepEqual(m, expectedMap) {
+		t.Errorf("The exprected map %+v does't correspond to %+v", expectedMap, m)
+	}
 }
 
-func TestIsStruct(t *testing.T) {
-	var T = struct{}{}
-
-	if !IsStruct(T) {
-		t.Errorf("T should be a struct, got: %T", T)
+func TestMap_Cycle(t *testing.T) {
+	type Node struct {
+		Name     string
+		Next     *Node
+		Children []*Node
 	}
 
-	if !IsStruct(&T) {
-		t.Errorf("T should be a struct, got: %T", T)
-	}
-}
+	self := &Node{Name: "self"}
+	self.Next = self
 
-func TestValues(t *testing.T) {
-	var T = struct {
-		A string
-		B int
-		C bool
-	}{
-		A: "a-value",
-		B: 2,
-		C: true,
+	if m := Map(self); m["Next"] != self {
+		t.Errorf("Map should store a pointer back to the struct as it is, got: %v", m["Next"])
 	}
 
-	s := Values(T)
+	a := &Node{Name: "a"}
+	b := &Node{Name: "b", Next: a}
+	a.Next = b
+	a.Children = []*Node{b}
 
-	if typ := reflect.TypeOf(s).Kind(); typ != reflect.Slice {
-		t.Errorf("Values should return a slice type, got: %v", typ)
+	m := Map(a)
+
+	next, ok := m["Next"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Map should convert the first visit of b, got: %T", m["Next"])
+	}
+	if next["Next"] != a {
+		t.Errorf("Map should store the pointer back to a as it is, got: %v", next["Next"])
 	}
 
-	inSlice := func(val interface{}) bool {
-		for _, v := range s {
-			if reflect.DeepEqual(v, val) {
-				return true
-			}
-		}
-		return false
+	children := m["Children"].([]interface{})
+	if child, ok := children[0].(map[string]interface{}); !ok || child["Name"] != "b" {
+		t.Errorf("Map should convert shared pointers which are not cycles, got: %v", children[0])
 	}
 
-	for _, val := range []interface{}{"a-value", 2, true} {
-		if !inSlice(val) {
-			t.Errorf("Values should have the value %v", val)
-		}
+	// the same struct is converted every time Map is called
+	if m := Map(self); m["Name"] != "self" || m["Next"] != self {
+		t.Errorf("Map should not keep cycle state between calls, got: %v", m)
 	}
 }
 
-func TestValues_OmitEmpty(t *testing.T) {
+func TestMap_TimeField(t *testing.T) {
+	type A struct {
+		CreatedAt time.Time
+	}
+
+	a := &A{CreatedAt: time.Now().UTC()}
+	m := Map(a)
+
+	_, ok := m["CreatedAt"].(time.Time)
+	if !ok {
+		t.Error("Time field must be final")
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	type Address struct {
+		Street string
+		City   string `structs:"city"`
+	}
+
+	type Meta struct {
+		Version int
+	}
+
+	type Person struct {
+		Name      string
+		Age       int `structs:"age,omitempty"`
+		Address   Address
+		Addresses []Address
+		Born      time.Time
+		Raw       Address `structs:",omitnested"`
+		Meta      Meta    `structs:",flatten"`
+		Ignored   bool    `structs:"-"`
+	}
+
+	born := time.Now()
+	p := Person{
+		Name:      "fatih",
+		Address:   Address{Street: "main", City: "Ankara"},
+		Addresses: []Address{{City: "Berlin"}},
+		Born:      born,
+		Raw:       Address{City: "Istanbul"},
+		Meta:      Meta{Version: 2},
+	}
+
+	want := []KeyValue{
+		{Key: "Name", Value: "fatih"},
+		{Key: "Address", Value: []KeyValue{
+			{Key: "Street", Value: "main"},
+			{Key: "city", Value: "Ankara"},
+		}},
+		{Key: "Addresses", Value: []interface{}{[]KeyValue{
+			{Key: "Street", Value: ""},
+			{Key: "city", Value: "Berlin"},
+		}}},
+		{Key: "Born", Value: born},
+		{Key: "Raw", Value: Address{City: "Istanbul"}},
+		{Key: "Version", Value: 2},
+	}
+
+	if got := New(p).OrderedMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OrderedMap result is wrong:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestOrderedMap_FlatnestedOverwrite(t *testing.T) {
+	type A struct {
+		Name string
+	}
+	type B struct {
+		Name string
+		A    `structs:",flatten"`
+		C    int
+	}
+
+	b := &B{Name: "b", A: A{Name: "a"}, C: 1}
+
+	want := []KeyValue{
+		{Key: "Name", Value: "b"},
+		{Key: "C", Value: 1},
+	}
+
+	if got := New(b).OrderedMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OrderedMap result is wrong:\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	type Meta struct {
+		Version int    `structs:"version"`
+		ID      string `structs:"id"`
+	}
+	type Server struct {
+		Host string `structs:"host"`
+		Port int    `structs:"port"`
+	}
+	type T struct {
+		ID     int    `structs:"id"`
+		Name   string `structs:"name,omitempty"`
+		Secret string `structs:"-"`
+		Meta   Meta   `structs:",inline"`
+		Server Server `structs:"server"`
+		Tags   []string
+	}
+
+	s := New(T{ID: 1, Meta: Meta{Version: 2}})
+
+	want := []string{"id", "version", "server", "Tags"}
+	if keys := s.Keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys: got %v, want %v", keys, want)
+	}
+
+	var mapKeys []string
+	for _, kv := range s.OrderedMap() {
+		mapKeys = append(mapKeys, kv.Key)
+	}
+	if keys := s.Keys(); !reflect.DeepEqual(keys, mapKeys) {
+		t.Errorf("Keys %v should match the keys of OrderedMap %v", keys, mapKeys)
+	}
+
+	// the names of Columns line up with Values instead
+	names, values := s.Columns()
+	if v := s.Values(); len(names) != len(v) || !reflect.DeepEqual(values, v) {
+		t.Errorf("Columns %v, %v should line up with Values %v", names, values, v)
+	}
+
+	s.NameFunc = func(fieldName, tagName string) string {
+		return strings.ToUpper(fieldName)
+	}
+	if keys, want := s.Keys(), []string{"ID", "VERSION", "SERVER", "TAGS"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys with NameFunc: got %v, want %v", keys, want)
+	}
+}
+
+func TestMapFunc(t *testing.T) {
+	type Account struct {
+		User     string `structs:"user"`
+		Password string `structs:"password,secret"`
+	}
+
+	type A struct {
+		Name    string
+		Created time.Time
+		Account Account
+		Count   int `structs:",string"`
+	}
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := A{
+		Name:    "a",
+		Created: created,
+		Account: Account{User: "u", Password: "p"},
+		Count:   1,
+	}
+
+	var names []string
+	m := New(a).MapFunc(func(f *Field, v interface{}) interface{} {
+		names = append(names, f.Name())
+		if _, opts := f.Tags("structs"); len(opts) > 0 && opts[0] == "secret" {
+			return "***"
+		}
+		if f.Name() == "Name" {
+			return Omit
+		}
+		if t, ok := v.(time.Time); ok {
+			return t.Format(time.RFC3339)
+		}
+		return v
+	})
+
+	want := map[string]interface{}{
+		"Created": "2020-01-02T03:04:05Z",
+		"Account": map[string]interface{}{"user": "u", "password": "***"},
+		"Count":   "1",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("MapFunc result is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	wantNames := []string{"Name", "Created", "User", "Password", "Account", "Count"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("MapFunc should call fn with %v, got: %v", wantNames, names)
+	}
+
+	// the callback is not kept by Map
+	if m := Map(a); m["Name"] != "a" {
+		t.Errorf("Map should not be affected by MapFunc, got: %v", m)
+	}
+}
+
+func TestMap_NameFunc(t *testing.T) {
+	type Server struct {
+		HostName string
+		Port     int `structs:"port_number"`
+	}
+
+	type Config struct {
+		UserName string
+		Server   Server
+		Ignored  string `structs:"-"`
+	}
+
+	c := &Config{UserName: "gopher", Server: Server{HostName: "localhost", Port: 80}}
+
+	s := New(c)
+	s.NameFunc = func(fieldName, tagName string) string {
+		if tagName != "" {
+			return tagName
+		}
+		return strings.ToLower(fieldName)
+	}
+
+	want := map[string]interface{}{
+		"username": "gopher",
+		"server": map[string]interface{}{
+			"hostname":    "localhost",
+			"port_number": 80,
+		},
+	}
+	m := s.Map()
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with NameFunc is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	out := &Config{}
+	n := New(out)
+	n.NameFunc = s.NameFunc
+	if err := n.FillStruct(m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, c) {
+		t.Errorf("FillStruct with NameFunc is wrong: %+v want: %+v", out, c)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	type Inner struct {
+		Name  string
+		Value int `api:"value"`
+	}
+
+	type A struct {
+		ID      int    `api:"id" structs:"id"`
+		Secret  string `structs:"secret"`
+		Inner   Inner  `api:"inner"`
+		Ignored string `api:"ignored" structs:"-"`
+	}
+
+	a := A{ID: 1, Secret: "s", Inner: Inner{Name: "n", Value: 2}, Ignored: "i"}
+
+	m := New(a).Filter(func(f *Field) bool {
+		return f.Tag("api") != ""
+	})
+
+	want := map[string]interface{}{
+		"id":    1,
+		"Inner": map[string]interface{}{"Name": "n", "Value": 2},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Filter result is wrong:\n got: %v\nwant: %v", m, want)
+	}
+}
+
+func TestStruct_Locker(t *testing.T) {
+	type Inner struct {
+		N int
+	}
+	type T struct {
+		mu    sync.Mutex
+		Count int
+		Inner Inner
+	}
+
+	v := &T{}
+	s := New(v)
+	s.Locker = &v.mu
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				v.mu.Lock()
+				v.Count++
+				v.Inner.N++
+				v.mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		m := s.Map()
+		if m["Count"] != m["Inner"].(map[string]interface{})["N"] {
+			t.Fatalf("Map with a Locker returned a torn read: %v", m)
+		}
+		if vals := s.Values(); vals[0] != vals[1] {
+			t.Fatalf("Values with a Locker returned a torn read: %v", vals)
+		}
+		s.OrderedMap()
+		s.Fields()
+	}
+	wg.Wait()
+
+	// the Locker is unlocked again afterwards
+	if !v.mu.TryLock() {
+		t.Fatal("Locker is still locked after Map")
+	}
+	v.mu.Unlock()
+}
+
+func TestMapRenamed(t *testing.T) {
+	type Inner struct {
+		ID int `structs:"id"`
+	}
+	type T struct {
+		ID    int    `structs:"id"`
+		Name  string `structs:"name"`
+		Inner Inner  `structs:"inner"`
+	}
+
+	s := New(T{ID: 1, Name: "gopher", Inner: Inner{ID: 2}})
+	m := s.MapRenamed(map[string]string{"ID": "uid", "Inner": "child", "Missing": "x"})
+
+	want := map[string]interface{}{
+		"uid":   1,
+		"name":  "gopher",
+		"child": map[string]interface{}{"id": 2}, // nested fields keep their keys
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("MapRenamed: got %#v, want %#v", m, want)
+	}
+
+	// the overrides don't stick to s
+	if m := s.Map(); m["id"] != 1 {
+		t.Errorf("Map after MapRenamed: got %#v, want the usual keys", m)
+	}
+}
+
+func TestMapExcept(t *testing.T) {
+	type Credentials struct {
+		User   string `structs:"user"`
+		Secret string `structs:"secret"`
+	}
+	type Account struct {
+		ID       int    `structs:"id"`
+		Password string `structs:"password"`
+		Token    string
+		Creds    Credentials  `structs:"creds"`
+		Backup   *Credentials `structs:"backup"`
+	}
+
+	a := Account{
+		ID:       1,
+		Password: "p",
+		Token:    "t",
+		Creds:    Credentials{User: "u", Secret: "s"},
+		Backup:   &Credentials{User: "b", Secret: "s"},
+	}
+
+	m := New(a).MapExcept("password", "Token", "Creds.secret", "backup.Secret", "missing", "creds.missing")
+	want := map[string]interface{}{
+		"id":     1,
+		"creds":  map[string]interface{}{"user": "u"},
+		"backup": map[string]interface{}{"user": "b"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("MapExcept: got %#v, want %#v", m, want)
+	}
+
+	if m := New(a).MapExcept(); !reflect.DeepEqual(m, Map(a)) {
+		t.Errorf("MapExcept without names should be the same as Map, got %#v", m)
+	}
+}
+
+func TestMapForGroups(t *testing.T) {
+	type Account struct {
+		IBAN  string `structs:"iban"`
+		Notes string `structs:"notes" groups:"internal"`
+	}
+	type User struct {
+		Name     string    `structs:"name"`
+		SSN      string    `structs:"ssn" groups:"admin, internal"`
+		Salary   int       `structs:"salary" groups:"hr"`
+		Accounts []Account `structs:"accounts"`
+	}
+
+	u := User{
+		Name:     "jane",
+		SSN:      "123",
+		Salary:   100,
+		Accounts: []Account{{IBAN: "CH00", Notes: "vip"}},
+	}
+
+	tests := []struct {
+		groups []string
+		want   map[string]interface{}
+	}{
+		{
+			groups: nil,
+			want: map[string]interface{}{
+				"name":     "jane",
+				"accounts": []interface{}{map[string]interface{}{"iban": "CH00"}},
+			},
+		},
+		{
+			groups: []string{"admin", "hr"},
+			want: map[string]interface{}{
+				"name":     "jane",
+				"ssn":      "123",
+				"salary":   100,
+				"accounts": []interface{}{map[string]interface{}{"iban": "CH00"}},
+			},
+		},
+		{
+			groups: []string{"internal"},
+			want: map[string]interface{}{
+				"name":     "jane",
+				"ssn":      "123",
+				"accounts": []interface{}{map[string]interface{}{"iban": "CH00", "notes": "vip"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if m := New(u).MapForGroups(tt.groups...); !reflect.DeepEqual(m, tt.want) {
+			t.Errorf("MapForGroups(%v) is wrong:\n got: %v\nwant: %v", tt.groups, m, tt.want)
+		}
+	}
+
+	if m := New(u).Map(); len(m) != 4 {
+		t.Errorf("Map should ignore groups, got: %v", m)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	type Owner struct {
+		Login string `structs:"login"`
+	}
+	type Repo struct {
+		ID      int    `structs:"id"`
+		Name    string `structs:"name"`
+		Stars   int    `structs:"Private"`
+		Owner   Owner  `structs:"owner"`
+		Private bool
+	}
+
+	r := Repo{ID: 1, Name: "structs", Stars: 7, Owner: Owner{Login: "oerlikon"}, Private: true}
+
+	m := New(r).Select("id", "owner", "Private", "missing")
+	want := map[string]interface{}{
+		"id":      1,
+		"owner":   map[string]interface{}{"login": "oerlikon"},
+		"Private": 7, // the tag name wins over the field name
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Select: got %#v, want %#v", m, want)
+	}
+
+	// field names are matched if no tag name matches
+	m = New(r).Select("Name")
+	if want := map[string]interface{}{"name": "structs"}; !reflect.DeepEqual(m, want) {
+		t.Errorf("Select by field name: got %#v, want %#v", m, want)
+	}
+
+	if m := New(r).Select(); len(m) != 0 {
+		t.Errorf("Select without names: got %#v, want an empty map", m)
+	}
+}
+
+func TestMap_DerefPointers(t *testing.T) {
+	type Inner struct {
+		N int
+	}
+	type T struct {
+		Name  *string
+		Count **int
+		Nil   *string
+		Inner *Inner
+		Empty *Inner
+		ID    *int `structs:"id,string"`
+	}
+
+	name, count, id := "gopher", 3, 7
+	pcount := &count
+	v := T{Name: &name, Count: &pcount, Inner: &Inner{N: 1}, ID: &id}
+
+	// pointers are stored as they are by default
+	if m := Map(v); m["Name"] != &name || m["Nil"] != (*string)(nil) {
+		t.Errorf("Map without DerefPointers: got %#v", m)
+	}
+
+	s := New(v)
+	s.DerefPointers = true
+	m := s.Map()
+
+	want := map[string]interface{}{
+		"Name":  "gopher",
+		"Count": 3,
+		"Nil":   nil,
+		"Inner": map[string]interface{}{"N": 1},
+		"Empty": nil,
+		"id":    "7",
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with DerefPointers: got %#v, want %#v", m, want)
+	}
+	if m["Nil"] != nil {
+		t.Errorf("A nil pointer should be stored as an untyped nil, got %#v", m["Nil"])
+	}
+}
+
+func TestMap_MaxDepth(t *testing.T) {
+	type C struct {
+		V int
+	}
+	type B struct {
+		C  C
+		Cs []C
+	}
+	type A struct {
+		Name string
+		B    B
+	}
+
+	a := A{Name: "a", B: B{C: C{V: 1}, Cs: []C{{V: 2}}}}
+
+	s := New(a)
+	s.MaxDepth = 2
+	m := s.Map()
+
+	want := map[string]interface{}{
+		"Name": "a",
+		"B": map[string]interface{}{
+			"C":  C{V: 1},
+			"Cs": []interface{}{C{V: 2}},
+		},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with MaxDepth 2: got %#v, want %#v", m, want)
+	}
+
+	s.MaxDepth = 1
+	if m := s.Map(); !reflect.DeepEqual(m["B"], a.B) {
+		t.Errorf("Map with MaxDepth 1 should store B as it is, got %#v", m["B"])
+	}
+
+	s.MaxDepth = 0
+	if m := s.Map(); !reflect.DeepEqual(m, Map(a)) {
+		t.Errorf("Map with MaxDepth 0 should not be limited, got %#v", m)
+	}
+}
+
+func TestMap_TagNames(t *testing.T) {
+	type Address struct {
+		City string `json:"city"`
+		Zip  string `db:"zip_code" json:"zip"`
+	}
+	type User struct {
+		ID      int     `db:"user_id" json:"id"`
+		Name    string  `json:"name,omitempty"`
+		Email   string  `db:"-" json:"email"`
+		Legacy  string  `db:",omitempty" json:"legacy"`
+		Other   string  `structs:"other"`
+		Address Address `db:"address"`
+	}
+
+	s := New(User{ID: 1, Email: "a@b.c", Address: Address{City: "Bern", Zip: "3000"}})
+	s.TagName = "structs"
+	s.TagNames = []string{"db", "json"}
+
+	want := map[string]interface{}{
+		"user_id": 1,
+		"Other":   "",
+		"address": map[string]interface{}{"city": "Bern", "zip_code": "3000"},
+	}
+	if m := s.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with TagNames is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	if names, _ := s.Columns(); !reflect.DeepEqual(names, []string{"user_id", "Other", "address.city", "address.zip_code"}) {
+		t.Errorf("Columns with TagNames is wrong: got %v", names)
+	}
+}
+
+func TestMap_TagOptionSep(t *testing.T) {
+	type T struct {
+		Name  string `structs:"name;omitempty"`
+		Count int    `structs:"count;string"`
+		Plain string `structs:"plain"`
+	}
+
+	s := New(T{Count: 3, Plain: "p"})
+	s.TagOptionSep = ";"
+
+	want := map[string]interface{}{"count": "3", "plain": "p"}
+	if m := s.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with TagOptionSep: got %#v, want %#v", m, want)
+	}
+
+	// the default separator reads the whole tag as the name
+	if m := Map(T{}); m["name;omitempty"] != "" {
+		t.Errorf("Map without TagOptionSep: got %#v", m)
+	}
+}
+
+func TestRegisterLeaf(t *testing.T) {
+	type ID struct {
+		Hi, Lo uint64
+	}
+	type Decimal struct {
+		Unscaled int64
+		Scale    int
+	}
+	type Money struct {
+		Amount   Decimal
+		Currency string
+	}
+
+	type Order struct {
+		ID     ID
+		Total  *Decimal
+		Refund Money
+	}
+
+	o := Order{ID: ID{Hi: 1, Lo: 2}, Total: &Decimal{Unscaled: 1050, Scale: 2}}
+
+	if m := Map(o); reflect.DeepEqual(m["ID"], o.ID) {
+		t.Fatal("ID should be converted to a map before registering it")
+	}
+
+	RegisterLeaf[ID]()
+	RegisterLeafType(reflect.TypeOf(&Decimal{}))
+	t.Cleanup(func() {
+		leafTypes.Lock()
+		defer leafTypes.Unlock()
+		delete(leafTypes.types, reflect.TypeOf(ID{}))
+		delete(leafTypes.types, reflect.TypeOf(Decimal{}))
+	})
+
+	s := New(o)
+	s.LeafTypes = []reflect.Type{reflect.TypeOf(Money{})}
+
+	want := map[string]interface{}{
+		"ID":     o.ID,
+		"Total":  o.Total,
+		"Refund": Money{},
+	}
+	if m := s.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with registered leaf types is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	// registered types apply to nested structs too
+	type Wrapper struct {
+		Price Money
+	}
+	want = map[string]interface{}{
+		"Price": map[string]interface{}{"Amount": Decimal{}, "Currency": ""},
+	}
+	if m := Map(Wrapper{}); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with a nested registered leaf type is wrong:\n got: %v\nwant: %v", m, want)
+	}
+}
+
+func TestMap_LeafTypes(t *testing.T) {
+	type Money struct {
+		Amount   int64
+		Currency string
+	}
+
+	type Order struct {
+		Total    Money
+		Refund   *Money
+		Items    []Money
+		Created  time.Time
+		Shipping Money `structs:",omitnested"`
+	}
+
+	created := time.Now()
+	o := Order{
+		Total:   Money{Amount: 100, Currency: "EUR"},
+		Refund:  &Money{Amount: 10, Currency: "EUR"},
+		Items:   []Money{{Amount: 90, Currency: "EUR"}},
+		Created: created,
+	}
+
+	s := New(o)
+	s.LeafTypes = []reflect.Type{reflect.TypeOf(Money{})}
+
+	want := map[string]interface{}{
+		"Total":    o.Total,
+		"Refund":   o.Refund,
+		"Items":    []interface{}{o.Items[0]},
+		"Created":  created,
+		"Shipping": Money{},
+	}
+	if m := s.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with LeafTypes is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	wantValues := []interface{}{o.Total, o.Refund, o.Items, created, Money{}}
+	if v := s.Values(); !reflect.DeepEqual(v, wantValues) {
+		t.Errorf("Values with LeafTypes is wrong:\n got: %v\nwant: %v", v, wantValues)
+	}
+
+	// time.Time is a leaf by default
+	type Event struct {
+		Name    string
+		Created time.Time
+	}
+
+	e := Event{Name: "e", Created: created}
+	if v := Values(e); !reflect.DeepEqual(v, []interface{}{"e", created}) {
+		t.Errorf("Values should keep time.Time values, got: %v", v)
+	}
+
+	if IsZero(e) {
+		t.Error("IsZero should be false for a non-zero time.Time")
+	}
+
+	if !HasZero(Event{Created: created}) || HasZero(e) {
+		t.Error("HasZero should treat time.Time as a single value")
+	}
+}
+
+func TestLeafTypes_MergeDiff(t *testing.T) {
+	type Money struct {
+		Amount   int64
+		Currency string
+	}
+	type Order struct {
+		Total Money
+	}
+	leaves := []reflect.Type{reflect.TypeOf(Money{})}
+
+	// leaf types are merged as a whole, not field by field
+	o := &Order{Total: Money{Amount: 1, Currency: "USD"}}
+	s := New(o)
+	s.LeafTypes = leaves
+	if err := s.Merge(Order{Total: Money{Amount: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Money{Amount: 100}); o.Total != want {
+		t.Errorf("Merge with LeafTypes: got %v, want %v", o.Total, want)
+	}
+
+	s = New(Order{Total: Money{Amount: 100, Currency: "EUR"}})
+	s.LeafTypes = leaves
+	other := Order{Total: Money{Amount: 100, Currency: "USD"}}
+	diff, err := s.Diff(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"Total": other.Total}; !reflect.DeepEqual(diff, want) {
+		t.Errorf("Diff with LeafTypes: got %v, want %v", diff, want)
+	}
+
+	if v := s.URLValues(); v.Encode() != "Total=%7B100+EUR%7D" {
+		t.Errorf("URLValues with LeafTypes: got %q", v.Encode())
+	}
+}
+
+func TestFillMap(t *testing.T) {
+	var T = struct {
+		A string
+		B int
+		C bool
+	}{
+		A: "a-value",
+		B: 2,
+		C: true,
+	}
+
+	a := make(map[string]interface{}, 0)
+	FillMap(T, a)
+
+	// we have three fields
+	if len(a) != 3 {
+		t.Errorf("FillMap should fill a map of len 3, got: %d", len(a))
+	}
+
+	inMap := func(val interface{}) bool {
+		for _, v := range a {
+			if reflect.DeepEqual(v, val) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, val := range []interface{}{"a-value", 2, true} {
+		if !inMap(val) {
+			t.Errorf("FillMap should have the value %v", val)
+		}
+	}
+}
+
+func TestFillMapWithTag(t *testing.T) {
+	type A struct {
+		Name  string `json:"name"`
+		Count int    `json:"count,omitempty"`
+		Skip  bool   `json:"-"`
+	}
+
+	out := map[string]interface{}{"name": "old", "other": 1}
+	FillMapWithTag(A{Name: "new", Skip: true}, out, "json")
+
+	want := map[string]interface{}{"name": "new", "other": 1}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("FillMapWithTag result is wrong: %v want: %v", out, want)
+	}
+
+	// should not panic
+	FillMapWithTag(A{}, nil, "json")
+}
+
+func TestFillMap_Nil(t *testing.T) {
+	var T = struct {
+		A string
+		B int
+		C bool
+	}{
+		A: "a-value",
+		B: 2,
+		C: true,
+	}
+
+	defer func() {
+		err := recover()
+		if err != nil {
+			t.Error("FillMap should not panic if a nil map is passed")
+		}
+	}()
+
+	// nil should no
+	FillMap(T, nil)
+}
+
+func TestFillStruct(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host"`
+		Port int64  `structs:"port"`
+	}
+
+	type Config struct {
+		Name    string
+		Tags    []string
+		Server  Server
+		Backup  *Server
+		Any     interface{}
+		Secret  string `structs:"-"`
+		private string
+	}
+
+	c := &Config{Name: "default", Secret: "keep", Any: 1}
+	err := New(c).FillStruct(map[string]interface{}{
+		"Tags":    []string{"a"},
+		"Server":  map[string]interface{}{"host": "localhost", "port": 80},
+		"Backup":  map[string]interface{}{"host": "backup"},
+		"Any":     nil,
+		"Secret":  "override",
+		"private": "override",
+		"Unknown": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		Name:   "default",
+		Tags:   []string{"a"},
+		Server: Server{Host: "localhost", Port: 80},
+		Backup: &Server{Host: "backup"},
+		Secret: "keep",
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("FillStruct result is wrong: %+v want: %+v", c, want)
+	}
+
+	// Map and FillStruct round trip
+	out := &Config{}
+	if err := New(out).FillStruct(Map(c)); err != nil {
+		t.Fatal(err)
+	}
+	want.Secret = ""
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("FillStruct of Map result is wrong: %+v want: %+v", out, want)
+	}
+
+	err = New(c).FillStruct(map[string]interface{}{
+		"Server": map[string]interface{}{"port": "80"},
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "Server: port: ") {
+		t.Errorf("FillStruct with a mismatched type should return an error naming the field, got: %v", err)
+	}
+
+	// slices and maps of structs are stored by Map element by element
+	type Cluster struct {
+		List    []Server          `structs:"list"`
+		Ptrs    []*Server         `structs:"ptrs"`
+		Servers map[string]Server `structs:"servers"`
+		Fleets  map[string][]Server
+	}
+
+	src := Cluster{
+		List:    []Server{{Host: "a", Port: 1}, {Host: "b"}},
+		Ptrs:    []*Server{{Host: "c"}, nil},
+		Servers: map[string]Server{"d": {Host: "d", Port: 4}},
+		Fleets:  map[string][]Server{"e": {{Host: "e"}}},
+	}
+	dst := &Cluster{}
+	if err := New(dst).FillStruct(Map(src)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, &src) {
+		t.Errorf("FillStruct of Map with slices and maps of structs is wrong: %+v want: %+v", dst, &src)
+	}
+
+	err = New(dst).FillStruct(map[string]interface{}{
+		"list": []interface{}{map[string]interface{}{"port": "80"}},
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "list[0]: port: ") {
+		t.Errorf("FillStruct with a mismatched element should return an error naming the element, got: %v", err)
+	}
+
+	if err := New(*c).FillStruct(nil); err != errPassedByValue {
+		t.Errorf("FillStruct into a non-settable struct should error with %q. Got %q instead.", errPassedByValue, err)
+	}
+}
+
+func TestFillStruct_Inline(t *testing.T) {
+	type Base struct {
+		ID   int
+		Name string
+	}
+	type Extra struct {
+		Note string
+	}
+	type Other struct {
+		Missing string
+	}
+	type T struct {
+		Base   `structs:",inline"`
+		Extra  *Extra `structs:"extra,inline"`
+		Name   string
+		Absent *Other `structs:",inline"`
+	}
+
+	in := T{Base: Base{ID: 1, Name: "base"}, Extra: &Extra{Note: "note"}, Name: "parent"}
+
+	m := Map(in)
+	want := map[string]interface{}{"ID": 1, "Name": "parent", "Note": "note"}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("Map with inline fields: got %#v, want %#v", m, want)
+	}
+
+	var out T
+	if err := New(&out).FillStruct(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// the colliding key goes to the parent only, as it does in Map
+	want2 := T{Base: Base{ID: 1}, Extra: &Extra{Note: "note"}, Name: "parent"}
+	if !reflect.DeepEqual(out, want2) {
+		t.Errorf("FillStruct with inline fields: got %+v, want %+v", out, want2)
+	}
+	if out.Absent != nil {
+		t.Errorf("Inlined pointer without any key should stay nil, got %+v", out.Absent)
+	}
+}
+
+func TestDecodeMap(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host"`
+		Port uint16 `structs:"port"`
+	}
+	type Config struct {
+		Name    string           `structs:"name"`
+		Retries int              `structs:"retries"`
+		Timeout time.Duration    `structs:"timeout"`
+		Since   time.Time        `structs:"since"`
+		Servers []Server         `structs:"servers"`
+		Ports   []int            `structs:"ports"`
+		Primary *Server          `structs:"primary"`
+		Limits  map[string]int64 `structs:"limits"`
+		Extra   interface{}      `structs:"extra"`
+	}
+
+	var in map[string]interface{}
+	data := `{
+		"name": "prod",
+		"retries": 3,
+		"timeout": "1m30s",
+		"since": "2020-01-02T03:04:05Z",
+		"servers": [{"host": "a", "port": 80}, {"host": "b", "port": 443}],
+		"ports": [1, 2],
+		"primary": {"host": "a", "port": 80},
+		"limits": {"cpu": 2},
+		"extra": [1]
+	}`
+	if err := json.Unmarshal([]byte(data), &in); err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	if err := New(&c).DecodeMap(in); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Name:    "prod",
+		Retries: 3,
+		Timeout: 90 * time.Second,
+		Since:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Servers: []Server{{"a", 80}, {"b", 443}},
+		Ports:   []int{1, 2},
+		Primary: &Server{"a", 80},
+		Limits:  map[string]int64{"cpu": 2},
+		Extra:   []interface{}{float64(1)},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("DecodeMap: got %+v, want %+v", c, want)
+	}
+
+	errs := []struct {
+		in   map[string]interface{}
+		path string
+	}{
+		{map[string]interface{}{"retries": 1.5}, "retries: "},
+		{map[string]interface{}{"timeout": "soon"}, "timeout: "},
+		{map[string]interface{}{"servers": []interface{}{map[string]interface{}{}, map[string]interface{}{"port": "x"}}}, "servers[1].port: "},
+		{map[string]interface{}{"servers": []interface{}{map[string]interface{}{"port": float64(1 << 20)}}}, "servers[0].port: "},
+		{map[string]interface{}{"limits": map[string]interface{}{"cpu": "x"}}, "limits.cpu: "},
+	}
+	for _, e := range errs {
+		err := New(&Config{}).DecodeMap(e.in)
+		if err == nil || !strings.HasPrefix(err.Error(), e.path) {
+			t.Errorf("DecodeMap(%v): got error %v, want prefix %q", e.in, err, e.path)
+		}
+	}
+
+	if err := New(Config{}).DecodeMap(in); err == nil {
+		t.Error("DecodeMap on a struct passed by value should return an error")
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host" db:"server_host"`
+		Port int    `structs:"port,omitempty" db:"-"`
+	}
+
+	type Config struct {
+		Name   string `structs:"name" json:"ignored"`
+		Secret string `structs:"-"`
+		Server Server `structs:"server"`
+		Raw    Server `structs:"raw,omitnested" db:"-"`
+	}
+
+	c := Config{
+		Name:   "a",
+		Secret: "b",
+		Server: Server{Host: "localhost"},
+		Raw:    Server{Host: "raw", Port: 1},
+	}
+
+	b, err := ToJSON(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"name":"a","raw":{"Host":"raw","Port":1},"server":{"host":"localhost"}}`
+	if string(b) != want {
+		t.Errorf("ToJSON result is wrong:\n got: %s\nwant: %s", b, want)
+	}
+
+	b, err = ToJSONWithTag(c, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = `{"Name":"a","Secret":"b","Server":{"server_host":"localhost"}}`
+	if string(b) != want {
+		t.Errorf("ToJSONWithTag result is wrong:\n got: %s\nwant: %s", b, want)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host"`
+		Port int    `structs:"port"`
+	}
+	type User struct {
+		UserID  int           `structs:"user_id" json:"id"`
+		Name    string        `structs:"name"`
+		Timeout time.Duration `structs:"timeout"`
+		Server  *Server       `structs:"server"`
+	}
+
+	in := User{UserID: 42, Name: "gopher", Server: &Server{Host: "a", Port: 80}}
+	data, err := New(in).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out User
+	if err := New(&out).DecodeJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("DecodeJSON(ToJSON()): got %+v, want %+v", out, in)
+	}
+
+	if err := New(&out).DecodeJSON([]byte(`{"timeout": "1m"}`)); err != nil || out.Timeout != time.Minute {
+		t.Errorf("DecodeJSON of a duration: got %v, %v", out.Timeout, err)
+	}
+
+	for _, data := range []string{`[1, 2]`, `{"user_id": "x"}`, `{`} {
+		if err := New(&User{}).DecodeJSON([]byte(data)); err == nil {
+			t.Errorf("DecodeJSON(%s) should fail", data)
+		}
+	}
+}
+
+func TestDecodeJSON_Inline(t *testing.T) {
+	type Base struct {
+		ID      int       `structs:"id"`
+		Created time.Time `structs:"created"`
+		Name    string    `structs:"name"`
+	}
+	type Audit struct {
+		By string `structs:"by"`
+	}
+	type Record struct {
+		Base  Base   `structs:",inline"`
+		Audit *Audit `structs:",flatten"`
+		Name  string `structs:"name"`
+		Count int64  `structs:"count"`
+	}
+
+	in := Record{
+		Base:  Base{ID: 7, Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Name: "base"},
+		Audit: &Audit{By: "jane"},
+		Name:  "record",
+		Count: 3,
+	}
+
+	data, err := New(in).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out Record
+	if err := New(&out).DecodeJSON(data); err != nil {
+		t.Fatalf("DecodeJSON: %v", err)
+	}
+
+	// the name key belongs to Record itself, which wins over the inlined one
+	want := in
+	want.Base.Name = ""
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("DecodeJSON(ToJSON()) with inlined fields:\n got: %+v\nwant: %+v", out, want)
+	}
+
+	var empty Record
+	if err := New(&empty).DecodeJSON([]byte(`{"name": "x"}`)); err != nil || empty.Audit != nil {
+		t.Errorf("DecodeJSON should not allocate an inlined pointer without keys: got %+v, %v", empty.Audit, err)
+	}
+
+	err = New(&out).DecodeJSON([]byte(`{"id": "x"}`))
+	if err == nil || !strings.HasPrefix(err.Error(), "id: ") {
+		t.Errorf("DecodeJSON error of an inlined field should have its path, got: %v", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host"`
+		Port int    `structs:"port"`
+	}
+	type T struct {
+		Name   string `structs:"name"`
+		Server Server `structs:"server"`
+		Debug  bool   `structs:"debug"`
+	}
+
+	v := T{Name: "app", Server: Server{Host: "a", Port: 80}, Debug: true}
+
+	var buf strings.Builder
+	enc := func(w io.Writer, key string, val interface{}) error {
+		_, err := fmt.Fprintf(w, "%s=%v;", key, val)
+		return err
+	}
+
+	s := New(v)
+	if err := s.Encode(&buf, enc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "name=app;server=[{host a} {port 80}];debug=true;"; got != want {
+		t.Errorf("Encode: got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	s.NestedSep = "."
+	if err := s.Encode(&buf, enc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "name=app;server.host=a;server.port=80;debug=true;"; got != want {
+		t.Errorf("Encode with NestedSep: got %q, want %q", got, want)
+	}
+
+	stop := fmt.Errorf("stop")
+	var keys []string
+	err := s.Encode(&buf, func(w io.Writer, key string, val interface{}) error {
+		keys = append(keys, key)
+		if key == "server.host" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Encode should return the error of enc, got: %v", err)
+	}
+	if want := []string{"name", "server.host"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Encode should stop at the first error, got keys %v, want %v", keys, want)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	type TLS struct {
+		Cert string `structs:"cert"`
+	}
+	type Server struct {
+		Host string   `structs:"host"`
+		TLS  *TLS     `structs:"tls"`
+		Tags []string `structs:"tags"`
+	}
+	type Config struct {
+		Name    string   `structs:"name"`
+		Server  Server   `structs:"server"`
+		Servers []Server `structs:"servers"`
+		Key     []byte   `structs:"key"`
+		Empty   []int    `structs:"empty"`
+	}
+
+	c := Config{
+		Name:    "app",
+		Server:  Server{Host: "a", TLS: &TLS{Cert: "a.pem"}, Tags: []string{"x", "y"}},
+		Servers: []Server{{Host: "b"}},
+		Key:     []byte("k"),
+		Empty:   []int{},
+	}
+
+	want := map[string]interface{}{
+		"name":            "app",
+		"server.host":     "a",
+		"server.tls.cert": "a.pem",
+		"server.tags[0]":  "x",
+		"server.tags[1]":  "y",
+		"servers[0].host": "b",
+		"servers[0].tls":  (*TLS)(nil),
+		"servers[0].tags": []string(nil),
+		"key":             []byte("k"),
+		"empty":           []int{},
+	}
+	if m := New(c).Flatten(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Flatten: got %#v, want %#v", m, want)
+	}
+
+	m := New(c).FlattenSep("_")
+	if m["server_tls_cert"] != "a.pem" || m["servers[0]_host"] != "b" {
+		t.Errorf("FlattenSep: got %#v", m)
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+	type TLS struct {
+		Cert string `structs:"cert"`
+	}
+	type Server struct {
+		Host    string        `structs:"host"`
+		TLS     *TLS          `structs:"tls"`
+		Tags    []string      `structs:"tags"`
+		Timeout time.Duration `structs:"timeout"`
+	}
+	type Config struct {
+		Name    string            `structs:"name"`
+		Server  Server            `structs:"server"`
+		Servers []Server          `structs:"servers"`
+		Grid    [2][]int          `structs:"grid"`
+		Note    *string           `structs:"note"`
+		Key     []byte            `structs:"key"`
+		Empty   *TLS              `structs:"empty"`
+		Timeout time.Duration     `structs:"timeout"`
+		Named   map[string]Server `structs:"named"`
+		Pools   map[string][]*TLS `structs:"pools"`
+	}
+
+	note := "n"
+	in := Config{
+		Name:    "app",
+		Server:  Server{Host: "a", TLS: &TLS{Cert: "a.pem"}, Tags: []string{"x", "y"}, Timeout: time.Second},
+		Servers: []Server{{Host: "b"}, {Host: "c", Tags: []string{"z"}, Timeout: 90 * time.Minute}},
+		Grid:    [2][]int{{1}, {2, 3}},
+		Note:    &note,
+		Key:     []byte("k"),
+		Empty:   &TLS{},
+		Timeout: time.Hour,
+		Named:   map[string]Server{"a": {Host: "a", TLS: &TLS{Cert: "a.pem"}}, "b": {Host: "b"}},
+		Pools:   map[string][]*TLS{"p": {{Cert: "p0.pem"}, {Cert: "p1.pem"}}},
+	}
+
+	var out Config
+	if err := New(&out).Unflatten(New(in).Flatten()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Unflatten(Flatten()): got %+v, want %+v", out, in)
+	}
+
+	var c Config
+	flat := map[string]interface{}{
+		"servers[2].tls.cert": "c.pem",
+		"note":                "direct",
+		"missing":             1,
+		"server.missing":      1,
+		"name[0]":             "x",
+	}
+	if err := New(&c).Unflatten(flat); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Servers) != 3 || c.Servers[2].TLS == nil || c.Servers[2].TLS.Cert != "c.pem" {
+		t.Errorf("Unflatten should grow slices and allocate pointers, got %+v", c.Servers)
+	}
+	if c.Note == nil || *c.Note != "direct" {
+		t.Errorf("Unflatten of a string into a *string: got %v", c.Note)
+	}
+
+	var untouched Config
+	unresolved := map[string]interface{}{
+		"servers[3].nope": 1,
+		"server.tls.nope": 2,
+		"empty.cert[0]":   3,
+		"grid[5][0]":      4,
+		"named.a.nope":    5,
+		"pools.p.cert":    6,
+	}
+	if err := New(&untouched).Unflatten(unresolved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(untouched, Config{}) {
+		t.Errorf("Unflatten of unresolved keys should not allocate anything, got %+v", untouched)
+	}
+
+	s := New(&Config{})
+	s.Strict = true
+	err := s.Unflatten(map[string]interface{}{"name": "x", "missing": 1})
+	if err == nil || !strings.HasPrefix(err.Error(), "missing: ") {
+		t.Errorf("Unflatten with Strict should name the unknown key, got: %v", err)
+	}
+
+	named := Config{Named: map[string]Server{"a": {Host: "a", Tags: []string{"x"}}}}
+	if err := New(&named).Unflatten(map[string]interface{}{"named.a.host": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[string]Server{"a": {Host: "b", Tags: []string{"x"}}}); !reflect.DeepEqual(named.Named, want) {
+		t.Errorf("Unflatten into an existing map element: got %+v, want %+v", named.Named, want)
+	}
+
+	err = New(&Config{}).Unflatten(map[string]interface{}{"servers[0].host": 1})
+	if err == nil || !strings.HasPrefix(err.Error(), "servers[0].host: ") {
+		t.Errorf("Unflatten should name the key that can't be set, got: %v", err)
+	}
+}
+
+func TestMapStringString(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host"`
+		Port int    `structs:"port"`
+	}
+	type T struct {
+		Name    string         `structs:"name"`
+		Ratio   float64        `structs:"ratio"`
+		On      bool           `structs:"on"`
+		Timeout time.Duration  `structs:"timeout"`
+		Server  Server         `structs:"server"`
+		Backup  *Server        `structs:"backup"`
+		Raw     Server         `structs:"raw,omitnested"`
+		Tags    []string       `structs:"tags"`
+		Labels  map[string]int `structs:"labels"`
+		Note    *string        `structs:"note"`
+		Any     interface{}    `structs:"any"`
+		Empty   string         `structs:"empty,omitempty"`
+		Skipped string         `structs:"-"`
+	}
+
+	v := T{
+		Name:    "app",
+		Ratio:   0.5,
+		On:      true,
+		Timeout: time.Second,
+		Server:  Server{Host: "example.com", Port: 80},
+		Raw:     Server{Host: "raw", Port: 1},
+		Tags:    []string{"a", "b"},
+		Labels:  map[string]int{"x": 1},
+	}
+
+	want := map[string]string{
+		"name":        "app",
+		"ratio":       "0.5",
+		"on":          "true",
+		"timeout":     "1s",
+		"server.host": "example.com",
+		"server.port": "80",
+		"backup":      "",
+		"raw":         `{"Host":"raw","Port":1}`,
+		"tags":        `["a","b"]`,
+		"labels":      `{"x":1}`,
+		"note":        "",
+		"any":         "",
+	}
+	if m := New(v).MapStringString(); !reflect.DeepEqual(m, want) {
+		t.Errorf("MapStringString: got %#v, want %#v", m, want)
+	}
+}
+
+func TestURLValues(t *testing.T) {
+	type Address struct {
+		City string `structs:"city"`
+		Zip  *int   `structs:"zip"`
+	}
+
+	type Form struct {
+		Name    string `structs:"name"`
+		Age     int    `structs:"age,omitempty"`
+		Tags    []string
+		Scores  [2]float64
+		Addr    Address           `structs:"addr"`
+		Raw     Address           `structs:"raw,omitnested"`
+		Meta    map[string]bool   `structs:"meta"`
+		Born    time.Time         `structs:"born"`
+		Person  *Person           `structs:"person"`
+		Nil     *Address          `structs:"nil"`
+		Data    []byte            `structs:"data"`
+		Ignored string            `structs:"-"`
+		Extra   map[string]string `structs:"extra,omitempty"`
+	}
+
+	zip := 6000
+	born := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := Form{
+		Name:    "fatih",
+		Tags:    []string{"a", "b"},
+		Scores:  [2]float64{1.5, 2},
+		Addr:    Address{City: "Ankara", Zip: &zip},
+		Raw:     Address{City: "Berlin"},
+		Meta:    map[string]bool{"admin": true},
+		Born:    born,
+		Person:  &Person{Name: "John", Age: 23},
+		Data:    []byte("data"),
+		Ignored: "ignored",
+	}
+
+	want := url.Values{
+		"name":        {"fatih"},
+		"Tags":        {"a", "b"},
+		"Scores":      {"1.5", "2"},
+		"addr[city]":  {"Ankara"},
+		"addr[zip]":   {"6000"},
+		"raw":         {fmt.Sprint(Address{City: "Berlin"})},
+		"meta[admin]": {"true"},
+		"born":        {born.String()},
+		"person":      {"John(23)"},
+		"data":        {"data"},
+	}
+
+	if v := New(f).URLValues(); !reflect.DeepEqual(v, want) {
+		t.Errorf("URLValues result is wrong:\n got: %v\nwant: %v", v, want)
+	}
+}
+
+func TestGet(t *testing.T) {
+	type A struct {
+		Name    string
+		Count   int
+		Any     interface{}
+		private string
+	}
+
+	s := New(&A{Name: "a", Count: 1, Any: 2, private: "p"})
+
+	if name, ok := Get[string](s, "Name"); !ok || name != "a" {
+		t.Errorf("Get of field Name should return 'a', got: %q, %t", name, ok)
+	}
+
+	if any, ok := Get[interface{}](s, "Count"); !ok || any != 1 {
+		t.Errorf("Get of field Count as interface{} should return 1, got: %v, %t", any, ok)
+	}
+
+	if n, ok := Get[int](s, "Any"); !ok || n != 2 {
+		t.Errorf("Get of field Any as int should return 2, got: %v, %t", n, ok)
+	}
+
+	if n, ok := Get[int](s, "Name"); ok || n != 0 {
+		t.Errorf("Get of field Name as int should fail, got: %v, %t", n, ok)
+	}
+
+	if p, ok := Get[string](s, "private"); ok || p != "" {
+		t.Errorf("Get of unexported field should fail, got: %q, %t", p, ok)
+	}
+
+	if _, ok := Get[string](s, "Unknown"); ok {
+		t.Error("Get of unknown field should fail")
+	}
+
+	// F is promoted through a nil *Bar
+	if n, ok := Get[int](New(&Foo{}), "F"); ok || n != 0 {
+		t.Errorf("Get through a nil embedded pointer should fail, got: %v, %t", n, ok)
+	}
+
+	if n := MustGet[int](s, "Count"); n != 1 {
+		t.Errorf("MustGet of field Count should return 1, got: %d", n)
+	}
+
+	defer func() {
+		err := recover()
+		if err == nil {
+			t.Error("MustGet of a field with a different type should panic")
+		}
+	}()
+
+	_ = MustGet[bool](s, "Name")
+}
+
+func TestSetDefaults(t *testing.T) {
+	type DB struct {
+		Host string `default:"localhost"`
+		Port int    `default:"5432"`
+	}
+	type Config struct {
+		Name    string        `default:"app"`
+		Port    int           `structs:"port" default:"8080"`
+		Timeout time.Duration `default:"30s"`
+		Hosts   []string      `default:"a,b"`
+		Debug   bool
+		Skipped int `structs:"-" default:"1"`
+		DB      DB
+		Replica *DB
+		Missing *DB
+	}
+
+	c := &Config{Port: 9000, Replica: &DB{Host: "replica"}}
+	if err := New(c).SetDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		Name:    "app",
+		Port:    9000, // set before, not overridden
+		Timeout: 30 * time.Second,
+		Hosts:   []string{"a", "b"},
+		DB:      DB{Host: "localhost", Port: 5432},
+		Replica: &DB{Host: "replica", Port: 5432},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("SetDefaults: got %+v, want %+v", c, want)
+	}
+
+	type Bad struct {
+		Port  int `default:"http"`
+		Inner struct {
+			N int `default:"x"`
+		}
+	}
+	err := New(&Bad{}).SetDefaults()
+	if err == nil {
+		t.Fatal("SetDefaults with invalid defaults should fail")
+	}
+	for _, name := range []string{"Port: ", "Inner.N: "} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("SetDefaults error should mention %q, got: %s", name, err)
+		}
+	}
+
+	if err := New(Config{}).SetDefaults(); err == nil {
+		t.Error("SetDefaults on a struct passed by value should fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type DB struct {
+		Host string `structs:"host,required"`
+		Port int
+	}
+	type Config struct {
+		Name    string    `structs:"name,required"`
+		Token   string    `structs:",required"`
+		Created time.Time `structs:"created,required"`
+		DB      DB
+		Replica *DB
+		Backup  *DB `structs:"backup,required"`
+		Skipped int `structs:"-"`
+	}
+
+	// a zero time in another location is still a zero time
+	zero := time.Time{}.In(time.FixedZone("CET", 3600))
+	err := New(&Config{Token: "t", Created: zero, Replica: &DB{}}).Validate()
+	if err == nil {
+		t.Fatal("Validate should fail for unset required fields")
+	}
+
+	for _, name := range []string{"Name: ", "Created: ", "DB.Host: ", "Replica.Host: ", "Backup: "} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Validate error should mention %q, got: %s", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "Token") {
+		t.Errorf("Validate error should not mention set fields, got: %s", err)
+	}
+
+	ok := Config{
+		Name:    "app",
+		Token:   "t",
+		Created: time.Now(),
+		DB:      DB{Host: "db"},
+		Backup:  &DB{Host: "backup"},
+	}
+	if err := New(ok).Validate(); err != nil {
+		t.Errorf("Validate of a valid struct: got %v", err)
+	}
+}
+
+func TestDecodeEnv(t *testing.T) {
+	type DB struct {
+		Host string
+		Port int `env:"PORT"`
+	}
+
+	type Config struct {
+		Name     string
+		Port     int `env:"PORT"`
+		Debug    bool
+		Timeout  time.Duration `env:"TIMEOUT"`
+		Database DB            `env:"DB"`
+		Cache    *DB
+		Nil      *DB
+		Default  string `env:"DEFAULT"`
+		Ignored  string `env:"-"`
+		private  string
+	}
+
+	t.Setenv("APP_NAME", "gopher")
+	t.Setenv("APP_PORT", "8080")
+	t.Setenv("APP_DEBUG", "true")
+	t.Setenv("APP_TIMEOUT", "5s")
+	t.Setenv("APP_DB_HOST", "localhost")
+	t.Setenv("APP_DB_PORT", "5432")
+	t.Setenv("APP_CACHE_HOST", "cache")
+	t.Setenv("APP_NIL_HOST", "nil")
+	t.Setenv("APP_IGNORED", "ignored")
+	t.Setenv("APP_PRIVATE", "private")
+
+	c := &Config{Default: "default", Cache: &DB{Port: 6379}}
+	if err := New(c).DecodeEnv("APP_"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		Name:     "gopher",
+		Port:     8080,
+		Debug:    true,
+		Timeout:  5 * time.Second,
+		Database: DB{Host: "localhost", Port: 5432},
+		Cache:    &DB{Host: "cache", Port: 6379},
+		Default:  "default",
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("DecodeEnv result is wrong: %+v want: %+v", c, want)
+	}
+
+	type Required struct {
+		Token string `env:"TOKEN,required"`
+		Count int    `env:"COUNT"`
+	}
+
+	t.Setenv("REQ_COUNT", "many")
+
+	err := New(&Required{}).DecodeEnv("REQ_")
+	if err == nil {
+		t.Fatal("DecodeEnv should fail for a missing required variable and an invalid value")
+	}
+	if !strings.Contains(err.Error(), "REQ_TOKEN") || !strings.Contains(err.Error(), "REQ_COUNT") {
+		t.Errorf("DecodeEnv error should list every failed variable, got: %s", err)
+	}
+}
+
+func TestIsStruct(t *testing.T) {
+	var T = struct{}{}
+
+	if !IsStruct(T) {
+		t.Errorf("T should be a struct, got: %T", T)
+	}
+
+	if !IsStruct(&T) {
+		t.Errorf("T should be a struct, got: %T", T)
+	}
+}
+
+func TestValues(t *testing.T) {
+	var T = struct {
+		A string
+		B int
+		C bool
+	}{
+		A: "a-value",
+		B: 2,
+		C: true,
+	}
+
+	s := Values(T)
+
+	if typ := reflect.TypeOf(s).Kind(); typ != reflect.Slice {
+		t.Errorf("Values should return a slice type, got: %v", typ)
+	}
+
+	inSlice := func(val interface{}) bool {
+		for _, v := range s {
+			if reflect.DeepEqual(v, val) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, val := range []interface{}{"a-value", 2, true} {
+		if !inSlice(val) {
+			t.Errorf("Values should have the value %v", val)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	type Server struct {
+		Host string `structs:"host"`
+		Port int    `structs:"port,omitempty"`
+	}
+	type T struct {
+		ID      int    `structs:"id"`
+		Name    string `structs:"name,omitempty"`
+		Secret  string `structs:"-"`
+		private int
+		Server  Server    `structs:"server"`
+		Created time.Time `structs:"created"`
+		Level   int       `structs:"level,string"`
+	}
+
+	created := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	s := New(T{ID: 1, Server: Server{Host: "example.com"}, Created: created, Level: 3})
+
+	names, values := s.Columns()
+
+	wantNames := []string{"id", "server.host", "created", "level"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Columns names: got %v, want %v", names, wantNames)
+	}
+
+	wantValues := []interface{}{1, "example.com", created, "3"}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("Columns values: got %v, want %v", values, wantValues)
+	}
+
+	if v := s.Values(); !reflect.DeepEqual(v, values) {
+		t.Errorf("Values %v should line up with Columns %v", v, values)
+	}
+}
+
+func TestValues_OmitEmpty(t *testing.T) {
 	type A struct {
 		Name  string
 		Value int `structs:",omitempty"`
@@ -755,6 +2866,33 @@ func TestValues_OmitEmpty(t *testing.T) {
 	}
 }
 
+func TestValues_OmitEmptyNested(t *testing.T) {
+	type A struct {
+		Name  string `structs:",omitempty"`
+		Value int    `structs:",omitempty"`
+	}
+
+	type B struct {
+		A     A
+		Count int `structs:",omitempty"`
+		Label string
+	}
+
+	b := B{A: A{Value: 1}}
+
+	s := Values(b)
+
+	want := []interface{}{1, ""}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Values should drop empty fields tagged as omitempty in nested structs too, got: %v want: %v", s, want)
+	}
+
+	// the kept values line up with the keys kept by Map
+	if m := Map(b); len(m["A"].(map[string]interface{}))+len(m)-1 != len(s) {
+		t.Errorf("Values and Map should omit the same empty fields, got: %v and %v", s, m)
+	}
+}
+
 func TestValues_OmitNested(t *testing.T) {
 	type A struct {
 		Name  string
@@ -855,6 +2993,52 @@ func TestValues_Anonymous(t *testing.T) {
 	}
 }
 
+func TestValuesWithTag(t *testing.T) {
+	type A struct {
+		Name  string `json:"name"`
+		Value int    `json:",omitempty"`
+	}
+
+	type B struct {
+		A     A    `json:",omitnested"`
+		C     A    `json:"c"`
+		D     bool `json:"-"`
+		Count int  `structs:"-"`
+	}
+
+	b := B{
+		A:     A{Name: "a"},
+		C:     A{Name: "c", Value: 1},
+		D:     true,
+		Count: 2,
+	}
+
+	s := ValuesWithTag(b, "json")
+
+	want := []interface{}{A{Name: "a"}, "c", 1, 2}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("ValuesWithTag should return %v, got: %v", want, s)
+	}
+}
+
+func TestLen(t *testing.T) {
+	type A struct {
+		Name    string
+		Email   string `structs:"email,omitempty"`
+		Ignored string `structs:"-"`
+		private string
+		Nested  struct{ X, Y int }
+	}
+
+	if n := New(A{}).Len(); n != 3 {
+		t.Errorf("Len should be 3, got: %d", n)
+	}
+
+	if n := New(struct{}{}).Len(); n != 0 {
+		t.Errorf("Len of an empty struct should be 0, got: %d", n)
+	}
+}
+
 func TestNames(t *testing.T) {
 	var T = struct {
 		A string
@@ -888,6 +3072,86 @@ func TestNames(t *testing.T) {
 	}
 }
 
+func TestFieldNamesByKind(t *testing.T) {
+	type A struct {
+		Name    string
+		Email   *string
+		Age     int
+		Count   **int
+		Tags    []string
+		Ignored string `structs:"-"`
+		private string
+	}
+
+	want := map[reflect.Kind][]string{
+		reflect.String: {"Name"},
+		reflect.Ptr:    {"Email", "Count"},
+		reflect.Int:    {"Age"},
+		reflect.Slice:  {"Tags"},
+	}
+	if got := New(A{}).FieldNamesByKind(false); !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldNamesByKind result is wrong: %v want: %v", got, want)
+	}
+
+	want = map[reflect.Kind][]string{
+		reflect.String: {"Name", "Email"},
+		reflect.Int:    {"Age", "Count"},
+		reflect.Slice:  {"Tags"},
+	}
+	if got := New(A{}).FieldNamesByKind(true); !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldNamesByKind with elem result is wrong: %v want: %v", got, want)
+	}
+}
+
+func TestNamesWithTag(t *testing.T) {
+	type A struct {
+		ID      int    `db:"id"`
+		Name    string `db:",omitempty"`
+		Ignored string `db:"-"`
+		Other   string `json:"other"`
+		private string `db:"private"`
+		Email   string `db:"email"`
+	}
+
+	names := New(A{}).NamesWithTag("db")
+
+	want := []string{"id", "Name", "email"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("NamesWithTag should return %v, got: %v", want, names)
+	}
+
+	if names := New(A{}).NamesWithTag("yaml"); len(names) != 0 {
+		t.Errorf("NamesWithTag of a tag key without values should be empty, got: %v", names)
+	}
+}
+
+func TestTagMap(t *testing.T) {
+	type A struct {
+		ID      int    `db:"id"`
+		Name    string `db:",omitempty"`
+		Ignored string `db:"-"`
+		Other   string `json:"other"`
+		private string `db:"private"`
+		Email   string `db:"email"`
+	}
+
+	got := New(A{}).TagMap("db")
+	want := map[string]string{"ID": "id", "Name": "Name", "Email": "email"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TagMap should return %v, got: %v", want, got)
+	}
+
+	got = New(A{}).TagNameToField("db")
+	want = map[string]string{"id": "ID", "Name": "Name", "email": "Email"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TagNameToField should return %v, got: %v", want, got)
+	}
+
+	if got := New(A{}).TagMap("yaml"); len(got) != 0 {
+		t.Errorf("TagMap of a tag key without values should be empty, got: %v", got)
+	}
+}
+
 func TestFields(t *testing.T) {
 	var T = struct {
 		A string
@@ -921,6 +3185,103 @@ func TestFields(t *testing.T) {
 	}
 }
 
+func TestSettableFields(t *testing.T) {
+	type A struct {
+		ID      int    `structs:"id,readonly"`
+		Name    string `structs:"name"`
+		Ignored string `structs:"-"`
+		private string
+		Email   string
+	}
+
+	var names []string
+	for _, f := range New(&A{}).SettableFields() {
+		names = append(names, f.Name())
+	}
+
+	if want := []string{"Name", "Email"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("SettableFields should return %v, got: %v", want, names)
+	}
+
+	if fields := New(A{}).SettableFields(); len(fields) != 0 {
+		t.Errorf("SettableFields of a struct passed by value should be empty, got %d fields", len(fields))
+	}
+}
+
+func TestNonZeroFields(t *testing.T) {
+	type A struct {
+		Name    string
+		Count   int
+		Tags    []string
+		Ignored string `structs:"-"`
+		mu      sync.Mutex
+		private string
+	}
+
+	a := &A{Name: "a", Ignored: "b", private: "c"}
+	s := New(a)
+
+	names := func(fields []*Field) []string {
+		var n []string
+		for _, f := range fields {
+			n = append(n, f.Name())
+		}
+		return n
+	}
+
+	if got := names(s.NonZeroFields()); !reflect.DeepEqual(got, []string{"Name"}) {
+		t.Errorf("NonZeroFields should return [Name], got: %v", got)
+	}
+
+	if got := names(s.ZeroValuedFields()); !reflect.DeepEqual(got, []string{"Count", "Tags"}) {
+		t.Errorf("ZeroValuedFields should return [Count Tags], got: %v", got)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	type T struct {
+		A string
+		B int `structs:"-"`
+		c bool
+		D float64
+	}
+
+	s := New(&T{A: "a", D: 1.5})
+
+	var names []string
+	err := s.ForEach(func(f *Field) error {
+		names = append(names, f.Name())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for _, f := range s.Fields() {
+		want = append(want, f.Name())
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ForEach visited %v, want the same fields as Fields: %v", names, want)
+	}
+
+	stop := fmt.Errorf("stop")
+	names = nil
+	err = s.ForEach(func(f *Field) error {
+		names = append(names, f.Name())
+		if f.Name() == "c" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("ForEach should return the error of fn, got: %v", err)
+	}
+	if want := []string{"A", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ForEach should stop at the first error, visited: %v want: %v", names, want)
+	}
+}
+
 func TestFields_OmitNested(t *testing.T) {
 	type A struct {
 		Name    string
@@ -958,6 +3319,44 @@ func TestFields_OmitNested(t *testing.T) {
 	}
 }
 
+func TestFieldsOf(t *testing.T) {
+	type T struct {
+		A string `db:"a"`
+		B int    `db:"-"`
+		c bool
+	}
+
+	v := &T{A: "x"}
+	fields, err := FieldsOf(reflect.ValueOf(v), "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name())
+	}
+	if want := []string{"A", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("FieldsOf: got %v, want %v", names, want)
+	}
+
+	if err := fields[0].Set("y"); err != nil || v.A != "y" {
+		t.Errorf("Fields of a pointer should be settable, got %v, %q", err, v.A)
+	}
+
+	var nilT *T
+	for _, bad := range []reflect.Value{
+		{},
+		reflect.ValueOf(1),
+		reflect.ValueOf(nilT),
+		reflect.ValueOf([]T{}),
+	} {
+		if _, err := FieldsOf(bad, "db"); err == nil {
+			t.Errorf("FieldsOf(%v) should return an error", bad)
+		}
+	}
+}
+
 func TestFields_Anonymous(t *testing.T) {
 	type A struct {
 		Name string
@@ -989,6 +3388,137 @@ func TestFields_Anonymous(t *testing.T) {
 	}
 }
 
+func TestWalk(t *testing.T) {
+	type Inner struct {
+		Name string
+		Next *Inner
+	}
+
+	type Outer struct {
+		A       Inner
+		B       *Inner
+		Nil     *Inner
+		Skip    Inner
+		Ignored Inner `structs:"-"`
+		Count   int
+	}
+
+	o := &Outer{
+		A: Inner{Name: "a"},
+		B: &Inner{Name: "b", Next: &Inner{Name: "c"}},
+	}
+
+	var paths []string
+	err := New(o).Walk(func(path []string, f *Field) error {
+		paths = append(paths, strings.Join(path, "."))
+		if f.Name() == "Skip" {
+			return SkipField
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"A", "A.Name", "A.Next",
+		"B", "B.Name", "B.Next", "B.Next.Name", "B.Next.Next",
+		"Nil",
+		"Skip",
+		"Count",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Walk should visit %v, got: %v", want, paths)
+	}
+
+	stop := fmt.Errorf("stop")
+	paths = nil
+	err = New(o).Walk(func(path []string, f *Field) error {
+		paths = append(paths, strings.Join(path, "."))
+		if f.Name() == "Name" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Walk should return the error of the callback, got: %v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"A", "A.Name"}) {
+		t.Errorf("Walk should stop at the first error, visited: %v", paths)
+	}
+}
+
+func TestWalk_Cycle(t *testing.T) {
+	type Node struct {
+		Name string
+		Next *Node
+	}
+
+	a := &Node{Name: "a"}
+	b := &Node{Name: "b", Next: a}
+	a.Next = b
+
+	var paths []string
+	err := New(a).Walk(func(path []string, f *Field) error {
+		paths = append(paths, strings.Join(path, "."))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Name", "Next", "Next.Name", "Next.Next"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Walk of a cyclic struct: got %v, want %v", paths, want)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	type Node struct {
+		Name  string `structs:"name,required" default:"node"`
+		Count int    `structs:"count"`
+		Next  *Node  `structs:"next"`
+	}
+
+	n := &Node{Name: "a"}
+	n.Next = n
+
+	if m := New(n).MapStringString(); !reflect.DeepEqual(m, map[string]string{"name": "a", "count": "0"}) {
+		t.Errorf("MapStringString of a cyclic struct: got %v", m)
+	}
+
+	if v := New(n).URLValues(); v.Encode() != "count=0&name=a" {
+		t.Errorf("URLValues of a cyclic struct: got %q", v.Encode())
+	}
+
+	if err := New(n).Validate(); err != nil {
+		t.Errorf("Validate of a cyclic struct: got %v", err)
+	}
+
+	if v, want := New(n).Values(), []interface{}{"a", 0}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Values of a cyclic struct: got %v, want %v", v, want)
+	}
+
+	if c, _ := New(n).Columns(); !reflect.DeepEqual(c, []string{"name", "count"}) {
+		t.Errorf("Columns of a cyclic struct: got %v", c)
+	}
+
+	empty := &Node{}
+	empty.Next = empty
+	if err := New(empty).Validate(); err == nil || strings.Contains(err.Error(), "Next.") {
+		t.Errorf("Validate of a cyclic struct should report the field once, got: %v", err)
+	}
+
+	if err := New(empty).SetDefaults(); err != nil || empty.Name != "node" {
+		t.Errorf("SetDefaults of a cyclic struct: got %q, %v", empty.Name, err)
+	}
+
+	t.Setenv("NODE_COUNT", "3")
+	if err := New(n).DecodeEnv("NODE_"); err != nil || n.Count != 3 {
+		t.Errorf("DecodeEnv of a cyclic struct: got %d, %v", n.Count, err)
+	}
+}
+
 func TestIsZero(t *testing.T) {
 	var T = struct {
 		A string
@@ -1089,27 +3619,81 @@ func TestIsZero_Anonymous(t *testing.T) {
 		Name string
 		D    string
 	}
-	a := A{Name: "example"}
+	a := A{Name: "example"}
+
+	type B struct {
+		A
+		C int
+	}
+	b := &B{C: 123}
+	b.A = a
+
+	ok := IsZero(b)
+	if ok {
+		t.Error("IsZero should return false because A, B and C are initialized")
+	}
+
+	aZero := A{}
+	bZero := &B{}
+	bZero.A = aZero
+
+	ok = IsZero(bZero)
+	if !ok {
+		t.Error("IsZero should return true because neither A nor B is initialized")
+	}
+}
+
+func TestIsDeepZero(t *testing.T) {
+	type Inner struct {
+		Name  string
+		Count *int
+	}
+
+	type Node struct {
+		Next *Node
+	}
+
+	type A struct {
+		Inner   Inner
+		Ptr     *Inner
+		PtrPtr  **Inner
+		Created time.Time
+		Node    *Node
+		Ignored string `structs:"-"`
+		private string
+	}
+
+	zero := 0
+	ptr := &Inner{Count: &zero}
+	node := &Node{}
+	node.Next = node
+
+	a := &A{
+		Ptr:     ptr,
+		PtrPtr:  &ptr,
+		Node:    node,
+		Ignored: "ignored",
+		private: "private",
+	}
 
-	type B struct {
-		A
-		C int
+	if !IsDeepZero(a) {
+		t.Error("IsDeepZero should be true for pointers to zero values")
 	}
-	b := &B{C: 123}
-	b.A = a
 
-	ok := IsZero(b)
-	if ok {
-		t.Error("IsZero should return false because A, B and C are initialized")
+	if IsZero(a) {
+		t.Error("IsZero should be false for non-nil pointers")
 	}
 
-	aZero := A{}
-	bZero := &B{}
-	bZero.A = aZero
+	one := 1
+	ptr.Count = &one
+	if IsDeepZero(a) {
+		t.Error("IsDeepZero should be false if a leaf behind a pointer is not zero")
+	}
+	ptr.Count = nil
 
-	ok = IsZero(bZero)
-	if !ok {
-		t.Error("IsZero should return true because neither A nor B is initialized")
+	a.Created = time.Now()
+	if IsDeepZero(a) {
+		t.Error("IsDeepZero should be false for a non-zero time.Time")
 	}
 }
 
@@ -1215,6 +3799,682 @@ func TestHasZero_Anonymous(t *testing.T) {
 	}
 }
 
+func TestIsZero_Unexported(t *testing.T) {
+	type T struct {
+		mu   sync.Mutex
+		Name string
+	}
+
+	var z T
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if !IsZero(&z) {
+		t.Error("IsZero should ignore unexported fields, got false")
+	}
+	if !HasZero(&z) {
+		t.Error("HasZero should ignore unexported fields, got false")
+	}
+
+	nz := &T{Name: "gopher"}
+	if IsZero(nz) {
+		t.Error("IsZero with a non-zero exported field: got true, want false")
+	}
+	if HasZero(nz) {
+		t.Error("HasZero without zero exported fields: got true, want false")
+	}
+}
+
+func TestSetAll(t *testing.T) {
+	type Cached struct {
+		ID      int
+		Name    string
+		Tags    []string
+		Hits    int
+		Skipped string `structs:"-"`
+	}
+	type Loaded struct {
+		ID      int
+		Name    string
+		Tags    []string
+		Hits    string // different type, skipped
+		Extra   bool
+		Skipped string
+	}
+
+	c := &Cached{ID: 1, Name: "old", Tags: []string{"a"}, Hits: 5, Skipped: "keep"}
+
+	if err := New(c).SetAll(&Loaded{ID: 2, Hits: "x", Extra: true, Skipped: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Cached{ID: 2, Name: "", Tags: nil, Hits: 5, Skipped: "keep"}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("SetAll: got %+v, want %+v", c, want)
+	}
+
+	if err := New(c).SetAll("not a struct"); err == nil {
+		t.Error("SetAll from a non struct should fail")
+	}
+	if err := New(Cached{}).SetAll(Loaded{}); err == nil {
+		t.Error("SetAll on a struct passed by value should fail")
+	}
+}
+
+func TestScan(t *testing.T) {
+	type AddressDTO struct {
+		City string `structs:"city"`
+		Zip  string
+	}
+
+	type Address struct {
+		Town string `structs:"city"`
+		Zip  int
+	}
+
+	type UserDTO struct {
+		ID      int64
+		Name    string
+		Email   string `structs:"mail"`
+		Address AddressDTO
+		Tags    []string
+		Secret  string `structs:"-"`
+		Extra   bool
+	}
+
+	type User struct {
+		ID      int
+		Name    string
+		Mail    string `structs:"mail"`
+		Address Address
+		Tags    []string
+		Secret  string
+		private string
+	}
+
+	dto := UserDTO{
+		ID:      42,
+		Name:    "gopher",
+		Email:   "gopher@example.com",
+		Address: AddressDTO{City: "Ankara", Zip: "06000"},
+		Tags:    []string{"a"},
+		Secret:  "secret",
+		Extra:   true,
+	}
+
+	u := &User{Address: Address{Zip: 1}, private: "keep"}
+	if err := New(dto).Scan(u); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &User{
+		ID:      42,
+		Name:    "gopher",
+		Mail:    "gopher@example.com",
+		Address: Address{Town: "Ankara", Zip: 1},
+		Tags:    []string{"a"},
+		private: "keep",
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Errorf("Scan result is wrong: %+v want: %+v", u, want)
+	}
+
+	for _, dst := range []interface{}{nil, User{}, (*User)(nil), new(int)} {
+		if err := New(dto).Scan(dst); err == nil {
+			t.Errorf("Scan into %T should return an error", dst)
+		}
+	}
+}
+
+func TestFromStruct(t *testing.T) {
+	type User struct {
+		ID       int
+		Name     string
+		Password string
+	}
+	type UserDTO struct {
+		ID    int
+		Name  string
+		Email string
+	}
+
+	u := User{ID: 1, Name: "jane", Password: "secret"}
+
+	var dto UserDTO
+	s, err := FromStruct(&dto, u)
+	if err != nil {
+		t.Fatalf("FromStruct: %v", err)
+	}
+
+	if want := (UserDTO{ID: 1, Name: "jane"}); dto != want {
+		t.Errorf("FromStruct should copy the matching fields: got %+v, want %+v", dto, want)
+	}
+
+	if err := s.Field("Email").Set("jane@example.com"); err != nil || dto.Email != "jane@example.com" {
+		t.Errorf("FromStruct should wrap dst: got %q, %v", dto.Email, err)
+	}
+
+	if _, err := FromStruct(&dto, 42); err == nil {
+		t.Error("FromStruct from a non struct should fail")
+	}
+	if _, err := FromStruct(dto, u); err == nil {
+		t.Error("FromStruct into a non pointer should fail")
+	}
+}
+
+func TestZeroFields(t *testing.T) {
+	type A struct {
+		Name    string
+		Count   int
+		Tags    []string
+		Pool    *int `structs:"-"`
+		private string
+	}
+
+	n := 1
+	a := &A{Name: "a", Count: 1, Tags: []string{"a"}, Pool: &n, private: "keep"}
+
+	if err := New(a).ZeroFields("Name", "Pool"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &A{Count: 1, Tags: []string{"a"}, Pool: &n, private: "keep"}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ZeroFields result is wrong: %+v want: %+v", a, want)
+	}
+
+	if err := New(a).ZeroFields(); err != nil {
+		t.Fatal(err)
+	}
+
+	want = &A{Pool: &n, private: "keep"}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("ZeroFields result is wrong: %+v want: %+v", a, want)
+	}
+
+	err := New(a).ZeroFields("private", "Unknown", "Name")
+	if err == nil {
+		t.Fatal("ZeroFields of unexported and unknown fields should return an error")
+	}
+	if !strings.Contains(err.Error(), "private: "+errNotExported.Error()) || !strings.Contains(err.Error(), "Unknown") {
+		t.Errorf("ZeroFields error should list every failed field, got: %s", err)
+	}
+
+	err = New(*a).ZeroFields()
+	if err == nil || !strings.Contains(err.Error(), errPassedByValue.Error()) {
+		t.Errorf("ZeroFields of a non-settable struct should error with %q. Got %q instead.", errPassedByValue, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	type Server struct {
+		Host string
+		Port int
+	}
+
+	type Config struct {
+		Name    string
+		Debug   bool
+		Tags    []string
+		Server  Server
+		Created time.Time
+		Secret  string `structs:"-"`
+		private string
+	}
+
+	now := time.Now()
+	dst := &Config{
+		Name:    "default",
+		Tags:    []string{"a"},
+		Server:  Server{Host: "localhost", Port: 80},
+		Secret:  "keep",
+		private: "keep",
+	}
+	src := Config{
+		Debug:   true,
+		Server:  Server{Port: 8080},
+		Created: now,
+		Secret:  "override",
+		private: "override",
+	}
+
+	if err := New(dst).Merge(src); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Config{
+		Name:    "default",
+		Debug:   true,
+		Tags:    []string{"a"},
+		Server:  Server{Host: "localhost", Port: 8080},
+		Created: now,
+		Secret:  "keep",
+		private: "keep",
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("Merge result is wrong: %+v want: %+v", dst, want)
+	}
+
+	if err := New(dst).Merge(&src); err != nil {
+		t.Errorf("Merge should accept a pointer to struct: %s", err)
+	}
+
+	if err := New(dst).Merge(Server{}); err == nil {
+		t.Error("Merge of a different struct type should return an error")
+	}
+
+	if err := New(dst).Merge(nil); err == nil {
+		t.Error("Merge of nil should return an error")
+	}
+
+	if err := New(*dst).Merge(src); err != errPassedByValue {
+		t.Errorf("Merge into a non-settable struct should error with %q. Got %q instead.", errPassedByValue, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type Server struct {
+		Host string
+		Port int
+	}
+
+	type Config struct {
+		Name    string
+		Tags    []string
+		Server  Server
+		Backup  Server `structs:",omitnested"`
+		Created time.Time
+		Secret  string `structs:"-"`
+		private string
+	}
+
+	now := time.Now()
+	old := Config{
+		Name:    "a",
+		Tags:    []string{"x"},
+		Server:  Server{Host: "localhost", Port: 80},
+		Backup:  Server{Host: "backup", Port: 80},
+		Secret:  "a",
+		private: "a",
+	}
+	cur := &Config{
+		Name:    "a",
+		Tags:    []string{"x", "y"},
+		Server:  Server{Host: "localhost", Port: 8080},
+		Backup:  Server{Host: "backup", Port: 8080},
+		Created: now,
+		Secret:  "b",
+		private: "b",
+	}
+
+	d, err := New(old).Diff(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"Tags":        []string{"x", "y"},
+		"Server.Port": 8080,
+		"Backup":      Server{Host: "backup", Port: 8080},
+		"Created":     now,
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("Diff result is wrong: %v want: %v", d, want)
+	}
+
+	d, err = New(old).Diff(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d) != 0 {
+		t.Errorf("Diff of equal structs should be empty, got: %v", d)
+	}
+
+	if _, err := New(old).Diff(Server{}); err == nil {
+		t.Error("Diff with a different struct type should return an error")
+	}
+}
+
+func TestHash(t *testing.T) {
+	type Inner struct {
+		N int
+	}
+	type T struct {
+		Name    string
+		Inner   *Inner
+		Tags    []string
+		Labels  map[string]int
+		Created time.Time
+		Any     interface{}
+		Skipped int `structs:"-"`
+		cache   int
+	}
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	newT := func() T {
+		return T{
+			Name:    "a",
+			Inner:   &Inner{N: 1},
+			Tags:    []string{"x", "y"},
+			Labels:  map[string]int{"a": 1, "b": 2, "c": 3},
+			Created: created,
+			Any:     1,
+		}
+	}
+
+	hash := func(v interface{}) uint64 {
+		h, err := New(v).Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	a, b := newT(), newT()
+	b.Skipped, b.cache = 1, 2
+	b.Created = created.In(time.FixedZone("X", 3600))
+	if hash(a) != hash(b) {
+		t.Error("Structs with equal exported content should hash the same")
+	}
+	if hash(a) != hash(&a) {
+		t.Error("A struct and a pointer to it should hash the same")
+	}
+
+	for i, change := range []func(*T){
+		func(v *T) { v.Name = "b" },
+		func(v *T) { v.Inner.N = 2 },
+		func(v *T) { v.Inner = nil },
+		func(v *T) { v.Tags = []string{"y", "x"} },
+		func(v *T) { v.Labels["a"] = 2 },
+		func(v *T) { v.Created = created.Add(time.Nanosecond) },
+		func(v *T) { v.Any = "1" },
+	} {
+		c := newT()
+		change(&c)
+		if hash(c) == hash(a) {
+			t.Errorf("Change %d should change the hash", i)
+		}
+	}
+
+	type Cycle struct {
+		Next *Cycle
+	}
+	c := &Cycle{}
+	c.Next = c
+	if _, err := New(c).Hash(); err == nil {
+		t.Error("Hash of a reference cycle should fail")
+	}
+
+	type Func struct {
+		Fn func()
+	}
+	if _, err := New(Func{}).Hash(); err == nil {
+		t.Error("Hash of a func field should fail")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	type Server struct {
+		Host  string
+		cache map[string]string
+	}
+
+	type Config struct {
+		Name    string
+		Port    *int
+		Server  Server
+		Backup  *Server
+		Ignored string `structs:"-"`
+		mu      sync.Mutex
+		hits    int
+	}
+
+	port := 80
+	a := &Config{
+		Name:    "a",
+		Port:    &port,
+		Server:  Server{Host: "localhost", cache: map[string]string{"a": "b"}},
+		Backup:  &Server{Host: "backup"},
+		Ignored: "a",
+		hits:    1,
+	}
+	b := &Config{
+		Name:    "a",
+		Port:    &port,
+		Server:  Server{Host: "localhost"},
+		Backup:  &Server{Host: "backup", cache: map[string]string{}},
+		Ignored: "b",
+		hits:    2,
+	}
+
+	if !New(a).Equal(b) {
+		t.Error("Structs which only differ in unexported and ignored fields should be equal")
+	}
+
+	b.Server.Host = "remote"
+	if New(a).Equal(b) {
+		t.Error("Structs with different nested fields should not be equal")
+	}
+	b.Server.Host = "localhost"
+
+	// nil vs zero pointers
+	a.Port, a.Backup = nil, nil
+	zero := 0
+	b.Port, b.Backup = &zero, &Server{}
+
+	if New(a).Equal(b) {
+		t.Error("A nil pointer should not be equal to a pointer to zero value by default")
+	}
+
+	s := New(a)
+	s.NilEqualsZero = true
+	if !s.Equal(b) {
+		t.Error("A nil pointer should be equal to a pointer to zero value with NilEqualsZero")
+	}
+
+	if New(a).Equal(Server{}) {
+		t.Error("Structs of different types should not be equal")
+	}
+
+	if New(a).Equal(nil) {
+		t.Error("A struct should not be equal to nil")
+	}
+}
+
+func TestEqual_Cycle(t *testing.T) {
+	type Node struct {
+		Name string
+		Next *Node
+	}
+
+	ring := func(names ...string) *Node {
+		first := &Node{Name: names[0]}
+		last := first
+		for _, name := range names[1:] {
+			last.Next = &Node{Name: name}
+			last = last.Next
+		}
+		last.Next = first
+		return first
+	}
+
+	if !New(ring("a", "b")).Equal(ring("a", "b")) {
+		t.Error("Equal of two equal cyclic structs should be true")
+	}
+	if New(ring("a", "b")).Equal(ring("a", "c")) {
+		t.Error("Equal of two different cyclic structs should be false")
+	}
+
+	s := New(ring("", ""))
+	s.NilEqualsZero = true
+	if !s.Equal(&Node{}) {
+		t.Error("Equal with NilEqualsZero of a cyclic struct of zero values should be true")
+	}
+}
+
+func TestDeepEqualIgnore(t *testing.T) {
+	type Meta struct {
+		CreatedAt time.Time
+		UpdatedAt time.Time `structs:"updated_at"`
+		Version   int
+	}
+	type Record struct {
+		ID    int `structs:"id"`
+		Name  string
+		Meta  Meta
+		Owner *Meta
+		cache string
+	}
+
+	now := time.Now()
+	a := Record{ID: 1, Name: "a", Meta: Meta{CreatedAt: now, Version: 1}, Owner: &Meta{UpdatedAt: now}, cache: "x"}
+	b := Record{ID: 2, Name: "a", Meta: Meta{CreatedAt: now.Add(time.Hour), Version: 1}, Owner: &Meta{}, cache: "y"}
+
+	if New(a).DeepEqualIgnore(b) {
+		t.Error("DeepEqualIgnore without names should be the same as Equal")
+	}
+	if !New(a).DeepEqualIgnore(b, "id", "Meta.CreatedAt", "Owner.updated_at") {
+		t.Error("DeepEqualIgnore should ignore the given fields")
+	}
+	if !New(a).DeepEqualIgnore(&b, "ID", "Meta", "Owner", "Missing.Field") {
+		t.Error("DeepEqualIgnore should ignore whole nested structs")
+	}
+	if New(a).DeepEqualIgnore(b, "id", "Meta.CreatedAt") {
+		t.Error("DeepEqualIgnore should compare the fields not ignored")
+	}
+
+	b.Meta.Version = 2
+	if New(a).DeepEqualIgnore(b, "id", "Meta.CreatedAt", "Owner") {
+		t.Error("DeepEqualIgnore should compare the nested fields not ignored")
+	}
+
+	if New(a).DeepEqualIgnore(Meta{}, "ID") {
+		t.Error("DeepEqualIgnore of different types should be false")
+	}
+}
+
+func TestClone(t *testing.T) {
+	type Address struct {
+		City string
+	}
+
+	type Node struct {
+		Name string
+		Next *Node
+	}
+
+	type Person struct {
+		Name      string
+		Age       *int
+		Tags      []string
+		Addresses []*Address
+		Meta      map[string]*Address
+		Scores    [2]int
+		Any       interface{}
+		Home      Address
+		Born      time.Time
+		Node      *Node
+		Ignored   string `structs:"-"`
+		private   string
+	}
+
+	age := 42
+	node := &Node{Name: "loop"}
+	node.Next = node
+	p := &Person{
+		Name:      "fatih",
+		Age:       &age,
+		Tags:      []string{"a", "b"},
+		Addresses: []*Address{{City: "Ankara"}},
+		Meta:      map[string]*Address{"work": {City: "Berlin"}},
+		Scores:    [2]int{1, 2},
+		Any:       []int{1},
+		Home:      Address{City: "Istanbul"},
+		Born:      time.Now(),
+		Node:      node,
+		Ignored:   "ignored",
+		private:   "private",
+	}
+
+	c, ok := New(p).Clone().(*Person)
+	if !ok {
+		t.Fatalf("Clone should return a pointer to the struct type, got: %T", New(p).Clone())
+	}
+
+	if c.Ignored != "" || c.private != "" {
+		t.Errorf("Clone should leave ignored and unexported fields zero, got: %q, %q", c.Ignored, c.private)
+	}
+	c.Ignored, c.private = p.Ignored, p.private
+
+	// the node cycle can't be compared with DeepEqual, check it separately
+	if c.Node == p.Node || c.Node.Next != c.Node || c.Node.Name != "loop" {
+		t.Error("Clone should copy reference cycles as cycles of new values")
+	}
+	c.Node = p.Node
+
+	if !reflect.DeepEqual(c, p) {
+		t.Errorf("Clone result is wrong: %+v want: %+v", c, p)
+	}
+
+	*c.Age = 1
+	c.Tags[0] = "x"
+	c.Addresses[0].City = "x"
+	c.Meta["work"].City = "x"
+	c.Any.([]int)[0] = 2
+	if age != 42 || p.Tags[0] != "a" || p.Addresses[0].City != "Ankara" ||
+		p.Meta["work"].City != "Berlin" || p.Any.([]int)[0] != 1 {
+		t.Error("Clone should not share memory with the original struct")
+	}
+
+	if _, ok := New(*p).Clone().(*Person); !ok {
+		t.Error("Clone of a struct value should return a pointer to the struct type")
+	}
+}
+
+func TestCopyInto(t *testing.T) {
+	type Inner struct {
+		Tags []string
+	}
+	type A struct {
+		Name    string
+		Count   int
+		Inner   *Inner
+		Scores  map[string]int
+		Skipped string `structs:"-"`
+		private string
+	}
+
+	src := &A{Name: "src", Inner: &Inner{Tags: []string{"a"}}, Scores: map[string]int{"x": 1}}
+	dst := &A{Name: "old", Count: 5, Skipped: "kept", private: "kept"}
+
+	if err := New(src).CopyInto(dst); err != nil {
+		t.Fatalf("CopyInto: %v", err)
+	}
+
+	if dst.Name != "src" || !reflect.DeepEqual(dst.Inner, src.Inner) || !reflect.DeepEqual(dst.Scores, src.Scores) {
+		t.Errorf("CopyInto should copy the exported fields: got %+v", dst)
+	}
+	if dst.Count != 0 {
+		t.Errorf("CopyInto should zero fields which are zero in the source: got Count %d", dst.Count)
+	}
+	if dst.Skipped != "kept" || dst.private != "kept" {
+		t.Errorf("CopyInto should leave ignored and unexported fields: got %+v", dst)
+	}
+
+	src.Inner.Tags[0] = "changed"
+	src.Scores["x"] = 2
+	if dst.Inner.Tags[0] != "a" || dst.Scores["x"] != 1 {
+		t.Error("CopyInto should make a deep copy")
+	}
+
+	type B struct{ Name string }
+	for _, dst := range []interface{}{nil, A{}, (*A)(nil), &B{}} {
+		if err := New(src).CopyInto(dst); err == nil {
+			t.Errorf("CopyInto(%T) should fail", dst)
+		}
+	}
+}
+
 func TestName(t *testing.T) {
 	type Foo struct {
 		A string
@@ -1400,6 +4660,39 @@ func TestNonStringerTagWithStringOption(t *testing.T) {
 	}
 }
 
+func TestMap_StringOptionScalars(t *testing.T) {
+	type Level string
+
+	type A struct {
+		ID      int64   `structs:"id,string"`
+		Count   uint8   `structs:",string"`
+		Ratio   float32 `structs:",string"`
+		Enabled bool    `structs:",string"`
+		Level   Level   `structs:",string"`
+		Tags    []int   `structs:",string"`
+		Plain   int
+	}
+
+	a := A{ID: 42, Count: 7, Ratio: 0.1, Enabled: true, Level: "debug", Tags: []int{1}, Plain: 1}
+
+	want := map[string]interface{}{
+		"id":      "42",
+		"Count":   "7",
+		"Ratio":   "0.1",
+		"Enabled": "true",
+		"Level":   "debug",
+		"Plain":   1,
+	}
+	if m := Map(a); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map with string option is wrong:\n got: %v\nwant: %v", m, want)
+	}
+
+	wantValues := []interface{}{"42", "7", "0.1", "true", "debug", 1}
+	if v := Values(a); !reflect.DeepEqual(v, wantValues) {
+		t.Errorf("Values with string option is wrong:\n got: %v\nwant: %v", v, wantValues)
+	}
+}
+
 func TestMap_InterfaceValue(t *testing.T) {
 	type TestStruct struct {
 		A interface{}
@@ -1,6 +1,9 @@
 package structs
 
-import "strings"
+import (
+	"reflect"
+	"strings"
+)
 
 // tagOptions contains a slice of tag options.
 type tagOptions []string
@@ -15,6 +18,12 @@ func (t tagOptions) Has(opt string) bool {
 	return false
 }
 
+// Inline returns true if the "inline" option, or its alias "flatten", is
+// available in tagOptions.
+func (t tagOptions) Inline() bool {
+	return t.Has("inline") || t.Has("flatten")
+}
+
 // parseTag splits a struct field's tag into its name and a list of options
 // which comes after a name. A tag is in the form of: "name,option1,option2".
 // The name can be neglected.
@@ -26,6 +35,46 @@ func parseTag(tag string) (string, tagOptions) {
 	// "name,opt,opt2"
 	// ",opt"
 
-	res := strings.Split(tag, ",")
+	return parseTagSep(tag, defaultOptionSep)
+}
+
+// defaultOptionSep separates the name and the options of a tag unless
+// TagOptionSep is set.
+const defaultOptionSep = ","
+
+// parseTagSep is the same as parseTag, except that the name and the options
+// are separated by sep instead of a comma.
+func parseTagSep(tag, sep string) (string, tagOptions) {
+	res := strings.Split(tag, sep)
 	return res[0], res[1:]
 }
+
+// lookupTag returns the value of the first of the space separated tag keys in
+// keys that tag has, or an empty string if it has none of them.
+func lookupTag(tag reflect.StructTag, keys string) string {
+	if !strings.Contains(keys, " ") {
+		return tag.Get(keys)
+	}
+
+	for _, key := range strings.Fields(keys) {
+		if value, ok := tag.Lookup(key); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// validTagKey returns true if key can be used as a key in a struct tag, as
+// described in reflect.StructTag. It must not be empty nor contain a space, a
+// quote, a colon or a control character.
+func validTagKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r <= ' ' || r == '"' || r == ':' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
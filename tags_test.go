@@ -42,3 +42,58 @@ func TestParseTag_Opts(t *testing.T) {
 		}
 	}
 }
+
+func TestValidTagKey(t *testing.T) {
+	keys := []struct {
+		key   string
+		valid bool
+	}{
+		{"", false},
+		{"json", true},
+		{"my-tag_1", true},
+		{"my tag", false},
+		{"my:tag", false},
+		{`my"tag`, false},
+		{"my\ttag", false},
+	}
+
+	for _, key := range keys {
+		if validTagKey(key.key) != key.valid {
+			t.Errorf("validTagKey should return %t: %#v", key.valid, key)
+		}
+	}
+}
+
+func TestTagOptions_Inline(t *testing.T) {
+	tags := []struct {
+		tag    string
+		inline bool
+	}{
+		{"", false},
+		{"name,omitempty", false},
+		{",inline", true},
+		{"name,flatten", true},
+	}
+
+	for _, tag := range tags {
+		_, opts := parseTag(tag.tag)
+		if opts.Inline() != tag.inline {
+			t.Errorf("Inline of %q: got %v, want %v", tag.tag, opts.Inline(), tag.inline)
+		}
+	}
+}
+
+func TestParseTagSep(t *testing.T) {
+	name, opts := parseTagSep("name;omitempty;string", ";")
+	if name != "name" {
+		t.Errorf("parseTagSep name: got %q, want %q", name, "name")
+	}
+	if !opts.Has("omitempty") || !opts.Has("string") {
+		t.Errorf("parseTagSep options: got %v", opts)
+	}
+
+	// the comma is part of the name with another separator
+	if name, _ := parseTagSep("a,b", ";"); name != "a,b" {
+		t.Errorf("parseTagSep with a comma: got %q, want %q", name, "a,b")
+	}
+}